@@ -1,13 +1,9 @@
 package zar
 
 import (
-	"bytes"
 	"encoding/binary"
 	"errors"
-	"hash"
 	"io"
-
-	"github.com/andybalholm/brotli"
 )
 
 var (
@@ -16,18 +12,9 @@ var (
 	ErrIntegrityFailed = errors.New("message authentication code failed")
 )
 
-func (d *Decoder) unmarshalAlmanac(r io.Reader, offset int64) (*Almanac, error) {
-	// read n bytes from buffer which are in this crypto block but not in the
-	// compression block
-	discard := make([]byte, offset)
-	if _, err := r.Read(discard); err != nil {
-		return nil, err
-	}
-
-	return decodeAlmanac(brotli.NewReader(r), d.mac)
-}
-
-func decodeAlmanac(r io.Reader, h hash.Hash) (*Almanac, error) {
+// decodeAlmanac parses the decompressed almanac, it must have been
+// authenticated beforehand
+func decodeAlmanac(r io.Reader) (*Almanac, error) {
 
 	buf := make([]byte, 8)
 
@@ -36,9 +23,6 @@ func decodeAlmanac(r io.Reader, h hash.Hash) (*Almanac, error) {
 		return nil, err
 	}
 
-	// compute SipHash
-	h.Write(buf)
-
 	fileCount := binary.BigEndian.Uint64(buf)
 	almanac := &Almanac{
 		Files: make([]File, fileCount),
@@ -72,13 +56,6 @@ func decodeAlmanac(r io.Reader, h hash.Hash) (*Almanac, error) {
 			return nil, err
 		}
 
-		// compute SipHash continued
-		h.Write(block)
-		h.Write(size)
-		h.Write(modified)
-		h.Write(nameLen)
-		h.Write(name)
-
 		f := File{
 			Offset:   binary.BigEndian.Uint64(block),
 			Size:     binary.BigEndian.Uint64(size),
@@ -94,28 +71,13 @@ func decodeAlmanac(r io.Reader, h hash.Hash) (*Almanac, error) {
 		return nil, err
 	}
 
-	h.Write(nameLen)
-
 	note := make([]byte, binary.BigEndian.Uint16(nameLen))
 	if _, err := io.ReadFull(r, note); err != nil {
 		return nil, err
 	}
 
-	h.Write(note)
-
 	almanac.Note = note
 
-	// read SipHash
-	if _, err := io.ReadFull(r, buf); err != nil {
-		return nil, err
-	}
-
-	if !bytes.Equal(h.Sum(nil), buf) {
-		return nil, ErrIntegrityFailed
-	}
-
-	h.Reset()
-
 	return almanac, nil
 
 }
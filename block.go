@@ -1,18 +1,51 @@
 package zar
 
-// BlockMacSize is the size of the message authentication code for a block
-const BlockMacSize = 8
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"hash"
+)
+
+// BlockTagSize is the size of the tag authenticating the ciphertext of a
+// compression block or the almanac
+const BlockTagSize = sha256.Size
 
 // Block is a collection of files, or one large file, combined into
-// one buffer which is compressed together and authenticated with SipHash.
+// one buffer which is compressed together.
 //
 // This block represents a NON compressed buffer i.e. decompression has already
 // been executed.
+//
+// Within the archive each compressed block is followed by a HMAC-SHA256 tag of
+// its ciphertext, so a block is authenticated before it is decompressed.
 type Block []byte
 
-// MAC returns the MAC for the block.
+// newTag returns the MAC for a section of ciphertext. The section's offset
+// from the start of the body is included so a section and its tag can not be
+// moved elsewhere in the archive.
+func newTag(key []byte, offset uint64) hash.Hash {
+	h := hmac.New(sha256.New, key)
+
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, offset)
+	h.Write(buf)
+
+	return h
+}
+
+// blockEnd returns the index following the last file stored in the same
+// compression block as index[id], along with the uncompressed length of the
+// block.
 //
-// If len(block) < 8 the method will panic
-func (b Block) MAC() []byte {
-	return b[len(b)-BlockMacSize:]
+// index[id] must be the first file of its block.
+func blockEnd(index []File, id int) (int, uint64) {
+	size := uint64(0)
+
+	i := id
+	for ; i < len(index) && index[i].Offset == index[id].Offset; i++ {
+		size += index[i].Size
+	}
+
+	return i, size
 }
@@ -0,0 +1,274 @@
+package zar
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Chunked cipher suites
+//
+// The chunked suites split the encrypted body into chunks of ChunkSize
+// plaintext bytes, each sealed with an AEAD using the STREAM construction.
+// The nonce of each chunk is made up of a prefix taken from the archive's
+// nonce, the chunk's counter and a flag which is only set on the final chunk:
+//
+//	Nonce
+//	    - Prefix (nonce size - 5 bytes)
+//	    - Counter (4 bytes)
+//	    - Last Chunk Flag (1 byte)
+//
+// Every chunk is authenticated as it is read, so a reader verifies the body
+// incrementally in bounded memory. Reordered or truncated chunks fail to
+// open, the final chunk being identified by its flag rather than the master
+// MAC.
+//
+// The layout of the plaintext is the same for every suite, offsets in the
+// almanac refer to the plaintext and are mapped onto chunks when reading.
+
+// ChunkSize is the amount of plaintext sealed in each chunk
+const ChunkSize = 64 << 10
+
+// maxChunks is the most chunks sealed under one key, after which the counter
+// in the nonce would repeat
+const maxChunks = math.MaxUint32 + 1
+
+// ErrChunkCounter is returned when a body holds more chunks than the nonce's
+// counter can number
+var ErrChunkCounter = errors.New("chunk counter exhausted")
+
+// chunkedSuite reports whether the cipher suite seals the body in chunks
+func chunkedSuite(suite uint8) bool {
+	return suite == CipherAES256GCM || suite == CipherXChaCha20Poly1305
+}
+
+// newAEAD creates the AEAD used to seal the chunks of the cipher suite
+func newAEAD(suite uint8, key []byte) (cipher.AEAD, error) {
+	switch suite {
+	case CipherAES256GCM:
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+
+		return cipher.NewGCM(block)
+	case CipherXChaCha20Poly1305:
+		return chacha20poly1305.NewX(key)
+	default:
+		return nil, ErrUnsupportedCipher
+	}
+}
+
+// chunkNonce returns the nonce for the chunk
+func chunkNonce(aead cipher.AEAD, base []byte, counter uint32, last bool) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	prefix := len(nonce) - 5
+
+	copy(nonce[:prefix], base)
+	binary.BigEndian.PutUint32(nonce[prefix:], counter)
+
+	if last {
+		nonce[len(nonce)-1] = 1
+	}
+
+	return nonce
+}
+
+// chunkWriter seals plaintext into chunks
+type chunkWriter struct {
+	w     io.Writer
+	aead  cipher.AEAD
+	nonce []byte
+
+	buf     []byte
+	counter uint64
+}
+
+func newChunkWriter(w io.Writer, aead cipher.AEAD, nonce []byte) *chunkWriter {
+	return &chunkWriter{
+		w:     w,
+		aead:  aead,
+		nonce: nonce,
+		buf:   make([]byte, 0, ChunkSize+aead.Overhead()),
+	}
+}
+
+func (c *chunkWriter) Write(p []byte) (int, error) {
+	n := len(p)
+
+	for len(p) > 0 {
+		// only seal a full chunk once more data arrives, as the final chunk
+		// must be sealed with the last chunk flag by Close
+		if len(c.buf) == ChunkSize {
+			if err := c.seal(false); err != nil {
+				return 0, err
+			}
+		}
+
+		x := copy(c.buf[len(c.buf):ChunkSize], p)
+		c.buf = c.buf[:len(c.buf)+x]
+		p = p[x:]
+	}
+
+	return n, nil
+}
+
+// Close seals the final chunk
+func (c *chunkWriter) Close() error {
+	return c.seal(true)
+}
+
+func (c *chunkWriter) seal(last bool) error {
+	if c.counter >= maxChunks {
+		return ErrChunkCounter
+	}
+
+	nonce := chunkNonce(c.aead, c.nonce, uint32(c.counter), last)
+	sealed := c.aead.Seal(c.buf[:0], nonce, c.buf, nil)
+
+	if _, err := c.w.Write(sealed); err != nil {
+		return err
+	}
+
+	c.buf = c.buf[:0]
+	c.counter++
+	return nil
+}
+
+// chunkReader opens chunks in order from a reader
+type chunkReader struct {
+	r     *bufio.Reader
+	aead  cipher.AEAD
+	nonce []byte
+
+	counter uint64
+	// plain is the unread plaintext of the current chunk
+	plain []byte
+	buf   []byte
+	done  bool
+}
+
+func newChunkReader(r io.Reader, aead cipher.AEAD, nonce []byte) *chunkReader {
+	return &chunkReader{
+		r:     bufio.NewReader(r),
+		aead:  aead,
+		nonce: nonce,
+		buf:   make([]byte, ChunkSize+aead.Overhead()),
+	}
+}
+
+func (c *chunkReader) Read(p []byte) (int, error) {
+	for len(c.plain) == 0 {
+		if c.done {
+			return 0, io.EOF
+		}
+
+		if err := c.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(p, c.plain)
+	c.plain = c.plain[n:]
+
+	return n, nil
+}
+
+// open reads and authenticates the next chunk
+func (c *chunkReader) open() error {
+	if c.counter >= maxChunks {
+		return ErrChunkCounter
+	}
+
+	n, err := io.ReadFull(c.r, c.buf)
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		// a short chunk must be the final chunk
+		c.done = true
+	} else if err != nil {
+		return err
+	} else if _, err := c.r.Peek(1); err == io.EOF {
+		c.done = true
+	}
+
+	nonce := chunkNonce(c.aead, c.nonce, uint32(c.counter), c.done)
+	plain, err := c.aead.Open(c.buf[:0], nonce, c.buf[:n], nil)
+	if err != nil {
+		return ErrIntegrityFailed
+	}
+
+	c.plain = plain
+	c.counter++
+	return nil
+}
+
+// chunkBody provides random access to a body sealed in chunks
+type chunkBody struct {
+	r      io.ReaderAt
+	offset int64
+	// length is the size of the sealed chunks
+	length int64
+	aead   cipher.AEAD
+	nonce  []byte
+}
+
+// chunks returns the amount of chunks in the body
+func (c *chunkBody) chunks() int64 {
+	sealed := int64(ChunkSize + c.aead.Overhead())
+	return (c.length + sealed - 1) / sealed
+}
+
+func (c *chunkBody) size() int64 {
+	return c.length - (c.chunks() * int64(c.aead.Overhead()))
+}
+
+func (c *chunkBody) readAt(p []byte, offset uint64) error {
+	if int64(offset)+int64(len(p)) > c.size() {
+		return io.ErrUnexpectedEOF
+	}
+
+	sealed := int64(ChunkSize + c.aead.Overhead())
+	last := c.chunks() - 1
+	buf := make([]byte, sealed)
+
+	for len(p) > 0 {
+		chunk := int64(offset / ChunkSize)
+		if chunk >= maxChunks {
+			return ErrChunkCounter
+		}
+
+		start := chunk * sealed
+		n := sealed
+		if start+n > c.length {
+			n = c.length - start
+		}
+
+		if _, err := readAtFull(c.r, buf[:n], c.offset+start); err != nil {
+			return err
+		}
+
+		nonce := chunkNonce(c.aead, c.nonce, uint32(chunk), chunk == last)
+		plain, err := c.aead.Open(buf[:0], nonce, buf[:n], nil)
+		if err != nil {
+			return ErrIntegrityFailed
+		}
+
+		x := copy(p, plain[offset%ChunkSize:])
+		p = p[x:]
+		offset += uint64(x)
+	}
+
+	return nil
+}
+
+func (c *chunkBody) reader() io.Reader {
+	return newChunkReader(io.NewSectionReader(c.r, c.offset, c.length), c.aead, c.nonce)
+}
+
+// decrypt is a no-op as the plaintext is returned by readAt
+func (c *chunkBody) decrypt(p []byte, offset uint64) {}
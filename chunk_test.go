@@ -0,0 +1,177 @@
+package zar
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+func TestChunkedArchive(t *testing.T) {
+	for _, suite := range []uint8{CipherAES256GCM, CipherXChaCha20Poly1305} {
+		testChunkedArchive(t, suite)
+	}
+}
+
+func testChunkedArchive(t *testing.T, suite uint8) {
+	// spans several chunks
+	contents := streamContents(t)
+	archive := encodeStreamArchive(t, contents, WithCipherSuite(suite))
+
+	d, err := NewDecoder(bytes.NewReader(archive), testArchiveKey, int64(len(archive)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if d.Header().CipherSuite != suite {
+		t.Fatalf("expected cipher suite %d got %d", suite, d.Header().CipherSuite)
+	}
+
+	if err := d.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, c := range contents {
+		r, err := d.Open(string(rune('a'+i)) + ".bin")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if buf, _ := io.ReadAll(r); !bytes.Equal(buf, c) {
+			t.Fatal("contents did not match")
+		}
+	}
+
+	reader, err := d.Reader()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewStreamReader(iotest.HalfReader(bytes.NewReader(archive)), testArchiveKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range contents {
+		if _, err := reader.Next(); err != nil {
+			t.Fatal(err)
+		}
+
+		if buf, _ := io.ReadAll(reader); !bytes.Equal(buf, c) {
+			t.Fatal("contents did not match")
+		}
+
+		if _, err := s.Next(); err != nil {
+			t.Fatal(err)
+		}
+
+		if buf, _ := io.ReadAll(s); !bytes.Equal(buf, c) {
+			t.Fatal("contents did not match")
+		}
+	}
+
+	if _, err := s.Next(); err != io.EOF {
+		t.Fatalf("expected EOF got %v", err)
+	}
+}
+
+func TestChunkedArchiveTampered(t *testing.T) {
+	archive := encodeStreamArchive(t, streamContents(t), WithCipherSuite(CipherXChaCha20Poly1305))
+
+	// modify the second chunk
+	archive[testBodyStart(t, archive)+ChunkSize+100] ^= 1
+
+	d, err := NewDecoder(bytes.NewReader(archive), testArchiveKey, int64(len(archive)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the last block is sealed in the final chunk
+	if _, err := d.Open("d.bin"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := d.Open("c.bin"); err != ErrIntegrityFailed {
+		t.Fatalf("expected %q got %v", ErrIntegrityFailed, err)
+	}
+}
+
+func TestChunkReader(t *testing.T) {
+	aead, err := newAEAD(CipherAES256GCM, make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nonce := make([]byte, nonceSize)
+	plain := make([]byte, ChunkSize*2+100)
+	if _, err := rand.Read(plain); err != nil {
+		t.Fatal(err)
+	}
+
+	sealed := bytes.NewBuffer(nil)
+	w := newChunkWriter(sealed, aead, nonce)
+	if _, err := w.Write(plain); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	chunk := ChunkSize + aead.Overhead()
+	buf := sealed.Bytes()
+
+	buf2, err := io.ReadAll(newChunkReader(bytes.NewReader(buf), aead, nonce))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(buf2, plain) {
+		t.Fatal("contents did not match")
+	}
+
+	// the final chunk is removed
+	truncated := buf[:2*chunk]
+	if _, err := io.ReadAll(newChunkReader(bytes.NewReader(truncated), aead, nonce)); err != ErrIntegrityFailed {
+		t.Fatalf("expected %q got %v", ErrIntegrityFailed, err)
+	}
+
+	// the first two chunks are swapped
+	reordered := append(append(append([]byte{}, buf[chunk:2*chunk]...), buf[:chunk]...), buf[2*chunk:]...)
+	if _, err := io.ReadAll(newChunkReader(bytes.NewReader(reordered), aead, nonce)); err != ErrIntegrityFailed {
+		t.Fatalf("expected %q got %v", ErrIntegrityFailed, err)
+	}
+}
+
+func TestChunkCounter(t *testing.T) {
+	aead, err := newAEAD(CipherAES256GCM, make([]byte, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nonce := make([]byte, nonceSize)
+
+	// the last counter is used for the next chunk, the final chunk would
+	// repeat the first nonce
+	w := newChunkWriter(io.Discard, aead, nonce)
+	w.counter = maxChunks - 1
+	if _, err := w.Write(make([]byte, ChunkSize+1)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Close(); err != ErrChunkCounter {
+		t.Fatalf("expected %q got %v", ErrChunkCounter, err)
+	}
+
+	r := newChunkReader(bytes.NewReader(make([]byte, ChunkSize)), aead, nonce)
+	r.counter = maxChunks
+	if _, err := r.Read(make([]byte, 1)); err != ErrChunkCounter {
+		t.Fatalf("expected %q got %v", ErrChunkCounter, err)
+	}
+
+	body := &chunkBody{r: bytes.NewReader(nil), length: (maxChunks + 1) * int64(ChunkSize+aead.Overhead()), aead: aead, nonce: nonce}
+	if err := body.readAt(make([]byte, 1), maxChunks*ChunkSize); err != ErrChunkCounter {
+		t.Fatalf("expected %q got %v", ErrChunkCounter, err)
+	}
+}
@@ -1,9 +1,11 @@
 package zar
 
 import (
+	"crypto/aes"
 	"crypto/cipher"
 	"hash"
 	"io"
+	"math/big"
 )
 
 type streamCipher struct {
@@ -11,7 +13,9 @@ type streamCipher struct {
 	dst    io.Writer
 	stream cipher.Stream
 	mac    hash.Hash
-	size   uint64
+	// tag receives the ciphertext of the current section when not nil
+	tag  hash.Hash
+	size uint64
 }
 
 func newCipher(mac hash.Hash, r io.Reader, w io.Writer, stream cipher.Stream) streamCipher {
@@ -49,14 +53,102 @@ func (c *streamCipher) Read(p []byte) (int, error) {
 	return n, nil
 }
 
+// Write encrypts p when stream is set, chunked suites leave the encryption
+// to dst and so the tag receives the plaintext.
 func (c *streamCipher) Write(p []byte) (int, error) {
 	// encrypt input and write mac using cipher text (Encrypt then Mac, EtM)
-	c.stream.XORKeyStream(p, p)
-	_, err := c.mac.Write(p)
-	if err != nil {
-		return 0, err
+	if c.stream != nil {
+		c.stream.XORKeyStream(p, p)
+		if _, err := c.mac.Write(p); err != nil {
+			return 0, err
+		}
+	}
+
+	if c.tag != nil {
+		c.tag.Write(p)
 	}
+
 	c.size += uint64(len(p))
 
 	return c.dst.Write(p)
 }
+
+// body provides random access to the plaintext layout of the encrypted body,
+// offsets are relative to the start of the plaintext
+type body interface {
+	// readAt reads the bytes covered by a section's tag at offset, the
+	// ciphertext for CTR and the authenticated plaintext for chunked suites
+	readAt(p []byte, offset uint64) error
+	// decrypt decrypts p, as returned by readAt at offset, in place
+	decrypt(p []byte, offset uint64)
+	// size returns the length of the plaintext
+	size() int64
+	// reader returns a sequential reader of the body from its start, which
+	// returns the same bytes as readAt
+	reader() io.Reader
+}
+
+// newBody returns the body of length bytes at offset in r, encrypted with the
+// cipher suite's key and nonce
+func newBody(r io.ReaderAt, suite uint8, key, nonce []byte, offset, length int64) (body, error) {
+	if chunkedSuite(suite) {
+		aead, err := newAEAD(suite, key)
+		if err != nil {
+			return nil, err
+		}
+
+		return &chunkBody{r: r, offset: offset, length: length, aead: aead, nonce: nonce}, nil
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ctrBody{r: r, offset: offset, length: length, block: block, iv: nonce[:block.BlockSize()]}, nil
+}
+
+// ctrBody is a body encrypted with a single CTR keystream
+type ctrBody struct {
+	r      io.ReaderAt
+	offset int64
+	length int64
+	block  cipher.Block
+	iv     []byte
+}
+
+func (c *ctrBody) readAt(p []byte, offset uint64) error {
+	_, err := readAtFull(c.r, p, c.offset+int64(offset))
+	return err
+}
+
+func (c *ctrBody) size() int64 {
+	return c.length
+}
+
+func (c *ctrBody) reader() io.Reader {
+	return io.NewSectionReader(c.r, c.offset, c.length)
+}
+
+// setCounter writes the CTR counter for the ciphertext block into ivBuf
+func (c *ctrBody) setCounter(block int64, ivBuf []byte) {
+	// initialise IV
+	counter := big.NewInt(0).SetBytes(c.iv)
+	// increment counter to block
+	counter.Add(counter, big.NewInt(block)).FillBytes(ivBuf)
+}
+
+// decrypt decrypts p in place, where p begins offset bytes into the body
+func (c *ctrBody) decrypt(p []byte, offset uint64) {
+	bs := uint64(c.block.BlockSize())
+
+	iv := make([]byte, bs)
+	c.setCounter(int64(offset/bs), iv)
+	stream := cipher.NewCTR(c.block, iv)
+
+	// advance the keystream to the offset within the cipher block
+	skip := make([]byte, offset%bs)
+	stream.XORKeyStream(skip, skip)
+
+	stream.XORKeyStream(p, p)
+}
@@ -1,13 +1,17 @@
 package zar
 
-import (
-	"crypto/aes"
-)
-
-// Header is the first 7 bytes of a file and contains metadata
+// Header is the first HeaderSize bytes of a file and contains metadata
 // on how to open it
 type Header struct {
 	MagicNumber [3]byte
+	// Version is the format version the archive was written with
+	Version uint8
+	// RequiredFeatures are features a decoder must understand to open
+	// the archive
+	RequiredFeatures Feature
+	// OptionalFeatures are features which a decoder may safely ignore
+	OptionalFeatures Feature
+
 	Mode        uint8
 	CipherSuite uint8
 	Mac         uint8
@@ -20,8 +24,9 @@ type Almanac struct {
 	Note []byte
 	// Files is a list of meta data pointing to the location of each file
 	Files []File
-	// MAC is SipHash used to authenticate this section has not
-	// been modified without having to authenticate the full archive
+	// MAC is the tag of the almanac's ciphertext used to authenticate this
+	// section has not been modified without having to authenticate the
+	// full archive
 	MAC []byte
 }
 
@@ -31,9 +36,10 @@ type File struct {
 	Name string
 	// Modified is the file modified date
 	Modified uint64
-	// Size refers to the size of the compressed file and mac
+	// Size refers to the uncompressed size of the file
 	Size uint64
-	// Offset is a offset from the start of the encrypted body
+	// Offset is the offset of the file's compression block from the start
+	// of the encrypted body
 	Offset uint64
 }
 
@@ -68,28 +74,31 @@ func (f *File) End(start uint64) uint64 {
 }
 
 // CipherBlock returns the ciphertext block ID of the compression
-// block
-func (f *File) CipherBlock() uint64 {
-	x := f.Offset % aes.BlockSize
-	return (f.Offset - x) / aes.BlockSize
+// block, where blockSize is the archive's Header.CipherBlockSize
+func (f *File) CipherBlock(blockSize uint64) uint64 {
+	x := f.Offset % blockSize
+	return (f.Offset - x) / blockSize
 }
 
 // CipherBlockOffset returns the distance between the start of the cipher's block
 // and the compression block's start
-func (f *File) CipherBlockOffset() uint64 {
-	cipherBlock := f.CipherBlock()
-	return f.Offset - (cipherBlock * aes.BlockSize)
+func (f *File) CipherBlockOffset(blockSize uint64) uint64 {
+	cipherBlock := f.CipherBlock(blockSize)
+	return f.Offset - (cipherBlock * blockSize)
 }
 
-// BlockSize returns the compressed length of the block
+// BlockSize returns the compressed length of the block.
+//
+// The length of the last block is not recorded in the index as it is
+// terminated by the almanac, in which case zero is returned.
 func (f *File) BlockSize(index []File, id int) uint64 {
 
-	// sum the sizes for all files within the same block
+	// find the offset of the next block
 	for i := id + 1; i < len(index); i++ {
 		if offset := index[i].Offset; offset != f.Offset {
-			return offset - f.Start(index, id)
+			return offset - f.Offset
 		}
 	}
 
-	return f.Size
+	return 0
 }
@@ -2,23 +2,17 @@ package zar
 
 import (
 	"bytes"
-	"crypto/aes"
-	"crypto/cipher"
+	"crypto/hmac"
 	"crypto/sha512"
 	"encoding/binary"
 	"errors"
 	"fmt"
-	"hash"
 	"io"
-	"math"
-	"math/big"
+	"io/fs"
 	"os"
 	"path/filepath"
 
 	"github.com/andybalholm/brotli"
-	"github.com/dchest/siphash"
-	"golang.org/x/crypto/argon2"
-	"golang.org/x/crypto/hkdf"
 )
 
 var (
@@ -26,6 +20,9 @@ var (
 	ErrShotRead = errors.New("short read")
 	// ErrFileName is returned when the file name is invalid
 	ErrFileName = errors.New("file name invalid")
+	// ErrFileNotFound is returned when the archive does not contain the
+	// requested file
+	ErrFileNotFound = fmt.Errorf("file not found in archive: %w", fs.ErrNotExist)
 	// ErrFilesTooMany is returned when a compression block has more files
 	// than the maximum
 	ErrFilesTooMany = errors.New("too many files in compression block")
@@ -33,208 +30,390 @@ var (
 
 const (
 	filePermissions = 0666
+	dirPermissions  = 0777
 )
 
 // Decoder will take a reader of the archive file
 type Decoder struct {
-	r   io.ReaderAt
-	key []byte
-
-	// masterMac is used with AES_256_CTR as a EtM MAC
-	masterMac hash.Hash
-	// mac is SipHash for each compression block
-	mac        hash.Hash
+	r      io.ReaderAt
+	header *Header
+	slots  []KeySlot
+	// unlocked is the index of the key slot the key unlocked
+	unlocked int
+
+	keys       *archiveKeys
+	nonce      []byte
 	size       int64
 	bodyOffset int64
 
-	block            cipher.Block
-	cipherBlockSize  int64
-	salt             []byte
+	// almanac is read once and cached by open
+	almanac      *Almanac
+	almanacStart uint64
+	// almanacHash is the SHA-512 of the compressed almanac
+	almanacHash []byte
+	// names maps a file name to its index in the almanac
+	names map[string]int
+
+	// body reads the plaintext layout of the encrypted body
+	body             body
 	compressionLevel int
 
 	// output is the directory to write files to
 	output string
+	// verify requires the master MAC to be checked before extraction
+	verify bool
+	// keyfiles is the digest of the keyfiles given with WithDecoderKeyfiles
+	keyfiles []byte
 }
 
-// NewDecoder creates a new zar archive decoder
-func NewDecoder(r io.ReaderAt, key []byte, size int64) (*Decoder, error) {
+// NewDecoder creates a new zar archive decoder.
+//
+// The archive header is read and validated before returning, an error is
+// returned if the input is not a zar archive or uses unsupported algorithms.
+// The key, either a password or a recipient's X25519 private key, is tried
+// against each of the archive's key slots in turn and ErrNoKeySlot is
+// returned if it unlocks none of them.
+func NewDecoder(r io.ReaderAt, key []byte, size int64, opts ...DecoderOption) (*Decoder, error) {
+	if size < HeaderSize {
+		return nil, ErrMagicNumber
+	}
+
+	buf := make([]byte, HeaderSize)
+	if _, err := readAtFull(r, buf, 0); err != nil {
+		return nil, err
+	}
+
+	header, err := unmarshalHeader(buf)
+	if err != nil {
+		return nil, err
+	}
 
-	return &Decoder{
+	d := &Decoder{
 		r:                r,
-		key:              key,
-		masterMac:        sha512.New(),
+		header:           header,
 		size:             size,
-		cipherBlockSize:  aes.BlockSize,
 		compressionLevel: brotli.DefaultCompression,
-	}, nil
+	}
+
+	for _, opt := range opts {
+		if err := opt(d); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := d.prepareDecoder(r, key, buf); err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// Header returns the archive's header
+func (d *Decoder) Header() Header {
+	return *d.header
 }
 
+// KeySlots returns the archive's key slots
+func (d *Decoder) KeySlots() []KeySlot {
+	return d.slots
+}
+
+// Version returns the format version the archive was written with
+func (d *Decoder) Version() uint8 {
+	return d.header.Version
+}
+
+// Extract decrypts and writes every file in the archive to the output
+// directory
 func (d *Decoder) Extract(output string) error {
-	r := d.r
 	d.output = output
 
-	if err := d.prepareDecoder(r); err != nil {
+	if d.verify {
+		if err := d.Verify(); err != nil {
+			return err
+		}
+	}
+
+	if err := d.open(); err != nil {
 		return err
 	}
 
-	ivBuf := make([]byte, d.cipherBlockSize)
-	almanac, err := getAlmanac(d, ivBuf)
-	if err != nil {
+	if err := d.extractFiles(d.almanac.Files); err != nil {
 		return err
 	}
 
-	if err := d.extractFiles(almanac.Files, ivBuf); err != nil {
+	return nil
+}
+
+// Verify authenticates the whole archive by recomputing the master MAC over
+// the full ciphertext and comparing it with the tag at the end of the archive.
+// ErrIntegrityFailed is returned if they do not match.
+//
+// Unlike the tag of each block this detects truncation and the removal
+// or reordering of blocks, but requires the entire archive to be read.
+func (d *Decoder) Verify() error {
+	length := d.size - d.bodyOffset - d.trailerSize()
+	if length < 0 {
+		return ErrIntegrityFailed
+	}
+
+	masterMac := hmac.New(sha512.New, d.keys.mac)
+	if _, err := io.Copy(masterMac, io.NewSectionReader(d.r, d.bodyOffset, length)); err != nil {
 		return err
 	}
 
+	tag := make([]byte, sha512.Size)
+	if _, err := readAtFull(d.r, tag, d.bodyOffset+length); err != nil {
+		return err
+	}
+
+	if !hmac.Equal(masterMac.Sum(nil), tag) {
+		return ErrIntegrityFailed
+	}
+
 	return nil
 }
 
-func (d *Decoder) extractFiles(files []File, ivBuf []byte) error {
+// Almanac returns the archive's index of files
+func (d *Decoder) Almanac() (*Almanac, error) {
+	if err := d.open(); err != nil {
+		return nil, err
+	}
 
-	// TODO: limit amount of open file descriptors
-	// create a list of file descriptors
-	fds := make([]*os.File, 0, len(files))
-	defer func() {
-		// close all descriptors on exit
-		for _, f := range fds {
-			f.Close()
-		}
-	}()
+	return d.almanac, nil
+}
+
+// Open decrypts, decompresses and authenticates a single file.
+//
+// Only the ciphertext of the compression block holding the file is read,
+// the rest of the archive is left untouched.
+func (d *Decoder) Open(name string) (io.ReadSeeker, error) {
+	if err := d.open(); err != nil {
+		return nil, err
+	}
+
+	id, ok := d.names[name]
+	if !ok {
+		return nil, ErrFileNotFound
+	}
+
+	contents, err := d.readFile(id)
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.NewReader(contents), nil
+}
+
+// readFile returns the authenticated contents of the file at index id in the
+// almanac
+func (d *Decoder) readFile(id int) ([]byte, error) {
+	files := d.almanac.Files
+	f := &files[id]
+
+	// find the first file in the block
+	first := id
+	for first > 0 && files[first-1].Offset == f.Offset {
+		first--
+	}
+
+	block, _, err := d.readBlock(files, first)
+	if err != nil {
+		return nil, err
+	}
+
+	start := f.Start(files, id)
+	return block[start:f.End(start)], nil
+}
 
-	// create directory structure and open files
+// open reads the almanac. Subsequent calls return immediately.
+func (d *Decoder) open() error {
+	if d.almanac != nil {
+		return nil
+	}
+
+	almanac, err := getAlmanac(d)
+	if err != nil {
+		return err
+	}
+
+	d.names = make(map[string]int, len(almanac.Files))
+	for i, f := range almanac.Files {
+		d.names[f.Name] = i
+	}
+
+	d.almanac = almanac
+	return nil
+}
+
+func (d *Decoder) extractFiles(files []File) error {
 	for _, f := range files {
 		if !validateName(f.Name) {
 			return ErrFileName
 		}
+	}
 
-		// create path & file
-		f, err := createPath(filepath.Join(d.output, f.Name))
+	for i := 0; i < len(files); {
+		// authenticate the whole block before any of its files are written
+		block, end, err := d.readBlock(files, i)
 		if err != nil {
 			return err
 		}
 
-		fds = append(fds, f)
-	}
+		offset := uint64(0)
+		for ; i < end; i++ {
+			f := &files[i]
+			if err := writeFile(filepath.Join(d.output, f.Name), block[offset:f.End(offset)]); err != nil {
+				return err
+			}
 
-	// START FIRST ATTEMPT
-	for _, f := range files {
-		// ignore files which have no contents
-		if f.Size == 0 {
-			continue
+			offset = f.End(offset)
 		}
+	}
 
-		// cipherBlock 1 (starting block)
-		cb1 := f.CipherBlock() //TODO: refactor cipherblock from uint64 to int64
-		// cipherBlock end
-		cbEnd := cb1 + uint64(math.Ceil(float64(f.Size)/aes.BlockSize))
+	return nil
+}
 
-		// TODO: convert to brotil stream (do not store file contents memory)
-		buf := bytes.NewBuffer(nil)
-		if err := d.decryptBlocks(int64(cb1), int64(cbEnd), ivBuf, buf); err != nil {
-			return err
-		}
-		fmt.Printf("%s CB1: %d - CB-End: %d\n", f.Name, cb1, cbEnd)
+// readBlock authenticates, decrypts and decompresses the compression block
+// which begins with files[id]. The index following the last file in the
+// block is returned along with the block.
+func (d *Decoder) readBlock(files []File, id int) (Block, int, error) {
+	f := &files[id]
+	end, size := blockEnd(files, id)
 
-		// trim unrelated data
-		fmt.Printf("\tCipherBlockOffset: %d\n", f.CipherBlockOffset())
-		fmt.Printf("\tCipherBlockOffset End: %d\n", f.CipherBlockOffset()+f.Size)
-		compressedFile := buf.Bytes()[f.CipherBlockOffset() : f.CipherBlockOffset()+f.Size-1]
+	raw := make([]byte, d.blockLength(files, id))
+	if err := d.body.readAt(raw, f.Offset); err != nil {
+		return nil, 0, err
+	}
 
-		fmt.Println(f.Name, compressedFile)
-		fmt.Printf("%s %X\n", f.Name, compressedFile)
-		fmt.Println(f.Name+" Len:", len(compressedFile))
+	block, err := d.decodeBlock(f, raw, size)
+	if err != nil {
+		return nil, 0, err
+	}
 
-		result := make([]byte, 200)
-		n, err := brotli.NewReader(bytes.NewBuffer(compressedFile)).Read(result)
-		if err != nil {
-			return err
+	return block, end, nil
+}
+
+// blockLength returns the length of the ciphertext of the block which begins
+// with files[id], including its tag
+func (d *Decoder) blockLength(files []File, id int) uint64 {
+	length := files[id].BlockSize(files, id)
+
+	// the last block is terminated by the almanac, which is preceded by
+	// its frame in streamable archives
+	if length == 0 {
+		length = d.almanacStart - files[id].Offset
+		if d.header.RequiredFeatures.Has(FeatureStreaming) {
+			length -= frameHeaderSize
 		}
+	}
 
-		// trim null data
-		result = result[:n]
+	return length
+}
 
-		fmt.Println(f.Name, string(result[:n]))
-		fmt.Println(f.Name, string(result[:n-8]))
+// blockReader returns a reader of the compressed block, joining the block's
+// frames in streamable archives
+func (d *Decoder) blockReader(r io.Reader) io.Reader {
+	if d.header.RequiredFeatures.Has(FeatureStreaming) {
+		return &frameReader{r: r}
+	}
 
-		mac := result[n-8:]
-		fmt.Println("len mac", len(mac))
+	return r
+}
 
-		fmt.Printf("MAC %X\n", mac)
+// decodeBlock authenticates and decrypts the ciphertext of the block which
+// begins with the file f before decompressing it. Size is the uncompressed
+// length of the block.
+func (d *Decoder) decodeBlock(f *File, raw []byte, size uint64) (Block, error) {
+	plain, err := d.openSection(d.keys.block, f.Offset, raw)
+	if err != nil {
+		return nil, err
+	}
 
-		// BUG: on the second file 7/8 bytes are null
-		d.mac.Write(result[:n-8])
-		if !bytes.Equal(d.mac.Sum(nil), mac) {
-			panic("message integrity failed")
+	if d.header.RequiredFeatures.Has(FeatureFileKeys) {
+		aead, err := d.keys.fileAEAD(d.header, f)
+		if err != nil {
+			return nil, err
 		}
 
-		d.mac.Reset()
+		return openFileBlock(aead, plain, size)
+	}
 
+	block := make(Block, size)
+	if _, err := io.ReadFull(brotli.NewReader(d.blockReader(bytes.NewReader(plain))), block); err != nil {
+		return nil, err
 	}
-	// END FIRST ATTEMPT
 
-	return nil
+	return block, nil
 }
 
-// prepareDecoder will setup the decoder with the appropriate ciphers, IVs, keys etc
-func (d *Decoder) prepareDecoder(r io.ReaderAt) error {
-	salt := make([]byte, aes.BlockSize)
-
-	if _, err := readAtFull(r, salt, 0); err != nil {
-		return err
+// openSection authenticates a section of the body which begins offset bytes
+// into it with the tag key and decrypts it in place. Raw holds the section, as returned by
+// readAt, followed by its tag, the plaintext is returned without the tag.
+func (d *Decoder) openSection(key []byte, offset uint64, raw []byte) ([]byte, error) {
+	if len(raw) < BlockTagSize {
+		return nil, ErrIntegrityFailed
 	}
 
-	d.salt = salt
+	n := len(raw) - BlockTagSize
 
-	d.bodyOffset = int64(len(salt) + len(d.salt))
+	// Encrypt then MAC, the tag is checked before the plaintext is used
+	mac := newTag(key, offset)
+	mac.Write(raw[:n])
 
-	// Run the key through Argon2Key KDF
-	k1 := argon2.Key(d.key, salt, 1, 20, 1, 32)
+	d.body.decrypt(raw, offset)
+	if !hmac.Equal(mac.Sum(nil), raw[n:]) {
+		return nil, ErrIntegrityFailed
+	}
 
-	// derive additional keys from master
-	kdf := hkdf.New(sha512.New, k1, nil, nil)
+	return raw[:n], nil
+}
 
-	// k2 is used for the master mac
-	k2 := make([]byte, 32)
-	// k3 is used for SipHash
-	k3 := make([]byte, 32)
-	// k4 is used for encryption
-	k4 := make([]byte, 32)
+// prepareDecoder will setup the decoder with the appropriate ciphers, IVs, keys etc
+func (d *Decoder) prepareDecoder(r io.ReaderAt, key, header []byte) error {
+	slots, n, err := readKeySlots(io.NewSectionReader(r, HeaderSize, d.size-HeaderSize))
+	if err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return ErrKeySlot
+		}
 
-	if _, err := io.ReadFull(kdf, k2); err != nil {
 		return err
 	}
 
-	if _, err := io.ReadFull(kdf, k3); err != nil {
+	nonce := make([]byte, nonceSize)
+	if _, err := readAtFull(r, nonce, HeaderSize+n); err != nil {
 		return err
 	}
 
-	if _, err := io.ReadFull(kdf, k4); err != nil {
-		return err
+	d.slots = slots
+	d.bodyOffset = HeaderSize + n + nonceSize
+
+	// length of the encrypted body without the master MAC
+	length := d.size - d.bodyOffset - d.trailerSize()
+	if length < 0 {
+		return ErrIntegrityFailed
 	}
 
-	block, err := aes.NewCipher(k4)
+	dataKey, unlocked, err := unlockKeySlots(slots, key, d.keyfiles, d.header)
 	if err != nil {
 		return err
 	}
 
-	d.block = block
-	d.mac = siphash.New(k3)
-
-	return nil
-}
+	keys, err := deriveKeys(dataKey, nonce, d.header)
+	if err != nil {
+		return err
+	}
 
-func (d *Decoder) almanacOffset(ivBuf []byte, lastBlock int64) (uint64, error) {
-	// decrypt last 2 ciphertext blocks
-	lastBlocks := bytes.NewBuffer(nil)
-	if err := d.decryptBlocks(lastBlock-2, lastBlock, ivBuf, lastBlocks); err != nil {
-		return 0, err
+	d.body, err = newBody(r, d.header.CipherSuite, keys.cipher, nonce, d.bodyOffset, length)
+	if err != nil {
+		return err
 	}
 
-	almanacOffset := pkcs5Unmarshal(lastBlocks.Bytes())
-	almanacOffset = almanacOffset[len(almanacOffset)-8:]
+	d.nonce = nonce
+	d.keys = keys
+	d.unlocked = unlocked
 
-	return binary.BigEndian.Uint64(almanacOffset), nil
+	return nil
 }
 
 func readAtFull(r io.ReaderAt, p []byte, offset int64) (int, error) {
@@ -250,69 +429,95 @@ func readAtFull(r io.ReaderAt, p []byte, offset int64) (int, error) {
 	return n, nil
 }
 
-func (d *Decoder) decryptBlocks(start, finish int64, ivBuf []byte, w io.Writer) error {
-
-	// initialise IV
-	counter := big.NewInt(0).SetBytes(d.salt)
-	// increment counter to block
-	counter.Add(counter, big.NewInt(start)).FillBytes(ivBuf)
+// getAlmanac locates the almanac using the offset at the end of the body, then
+// authenticates and decodes it
+func getAlmanac(d *Decoder) (*Almanac, error) {
+	bs := int64(padBlockSize)
 
-	c := cipher.NewCTR(d.block, ivBuf)
+	// length of the padded plaintext
+	length := d.body.size()
+	if length < 2*bs || length%bs != 0 {
+		return nil, ErrIntegrityFailed
+	}
 
-	p := make([]byte, d.cipherBlockSize)
-	for i := start; i < finish; i++ {
-		n, err := d.r.ReadAt(p, d.bodyOffset+(i*d.cipherBlockSize))
-		if err != nil {
-			return err
-		}
+	// decrypt the last 2 cipher blocks which hold the padding and offset
+	tail := make([]byte, 2*bs)
+	if err := d.body.readAt(tail, uint64(length-(2*bs))); err != nil {
+		return nil, err
+	}
 
-		// encrypt input and write mac using cipher text (Encrypt then Mac, EtM)
-		c.XORKeyStream(p[:n], p[:n])
-		_, err = d.masterMac.Write(p[:n])
-		if err != nil {
-			return err
-		}
+	d.body.decrypt(tail, uint64(length-(2*bs)))
 
-		if _, err := w.Write(p[:n]); err != nil {
-			return err
-		}
+	padding := int64(tail[len(tail)-1])
+	if padding == 0 || padding > bs {
+		return nil, ErrIntegrityFailed
 	}
 
-	return nil
-}
+	almanacOffset := binary.BigEndian.Uint64(tail[(2*bs)-padding-8:])
+
+	// end of the almanac's tag
+	end := uint64(length - padding - 8)
+	if almanacOffset > end {
+		return nil, ErrIntegrityFailed
+	}
 
-func getAlmanac(d *Decoder, ivBuf []byte) (*Almanac, error) {
-	lastBlock := (d.size - d.bodyOffset - 64) / d.cipherBlockSize
+	raw := make([]byte, end-almanacOffset)
+	if err := d.body.readAt(raw, almanacOffset); err != nil {
+		return nil, err
+	}
 
-	almanacOffset, err := d.almanacOffset(ivBuf, lastBlock)
+	plain, err := d.openSection(d.keys.almanac, almanacOffset, raw)
 	if err != nil {
 		return nil, err
 	}
 
-	// Calculate the block ID
-	row := (almanacOffset / 16) + 1
+	// plain is replaced by the inner plaintext under file keys
+	tag := raw[len(raw)-BlockTagSize:]
 
-	almanacBuf := bytes.NewBuffer(nil)
-	if err := d.decryptBlocks(int64(row)-1, lastBlock, ivBuf, almanacBuf); err != nil {
-		return nil, err
-	}
+	if d.header.RequiredFeatures.Has(FeatureFileKeys) {
+		aead, err := newAEAD(fileSuite(d.header), d.keys.almanacKey)
+		if err != nil {
+			return nil, err
+		}
 
-	// remove padding and almanac offset
-	almanacBuf.Truncate(len(pkcs5Unmarshal(almanacBuf.Bytes())) - 8)
+		if plain, err = io.ReadAll(newChunkReader(bytes.NewReader(plain), aead, nil)); err != nil {
+			return nil, err
+		}
+	}
 
-	almanac, err := d.unmarshalAlmanac(almanacBuf, int64(((row*16)+almanacOffset)%16))
+	almanac, err := decodeAlmanac(brotli.NewReader(bytes.NewReader(plain)))
 	if err != nil {
 		return nil, err
 	}
 
+	almanac.MAC = tag
+	almanacHash := sha512.Sum512(plain)
+	d.almanacHash = almanacHash[:]
+	d.almanacStart = almanacOffset
+
 	return almanac, nil
 }
 
 func createPath(path string) (*os.File, error) {
 
-	if err := os.MkdirAll(filepath.Dir(path), os.ModeExclusive); err != nil {
+	if err := os.MkdirAll(filepath.Dir(path), dirPermissions); err != nil {
 		return nil, err
 	}
 
-	return os.OpenFile(path, os.O_CREATE|os.O_WRONLY, filePermissions)
+	return os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, filePermissions)
+}
+
+// writeFile creates the file and its parent directories and writes p to it
+func writeFile(path string, p []byte) error {
+	f, err := createPath(path)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(p); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
 }
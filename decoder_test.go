@@ -2,6 +2,12 @@ package zar
 
 import (
 	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -19,17 +25,128 @@ func TestDecodeBlock(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	err = d.Extract("./testdata/extract")
+	output := t.TempDir()
+	err = d.Extract(output)
 	if err != nil {
 		t.Fatal(err)
 	}
-	// fmt.Println(d.decryptBlocks(1, 10, d.iv, os.Stdout))
 
+	expectFile(t, filepath.Join(output, "test.txt"), "my file contents...")
+	expectFile(t, filepath.Join(output, "test2.txt"), "another file")
+	expectFile(t, filepath.Join(output, "some/file.txt"), "mid 18th Century")
 }
 
-func encodeArchive() ([]byte, error) {
+func TestDecodeMultipleBlocks(t *testing.T) {
 	output := bytes.NewBuffer(nil)
-	archive, err := New(output, testArchiveKey)
+	archive, err := New(output, testArchiveKey, WithBlockSize(64))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contents := []string{
+		strings.Repeat("a", 20),
+		strings.Repeat("b", 20),
+		"",
+		strings.Repeat("c", 200),
+		"small",
+	}
+
+	names := []string{"a.txt", "b.txt", "empty.txt", "dir/c.txt", "dir/small.txt"}
+	for i, name := range names {
+		if _, err := archive.Add(name, 0, strings.NewReader(contents[i])); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a.txt, b.txt and empty.txt share a block, c.txt would overflow it
+	// and so occupies a block of its own
+	files := archive.almanac
+	if files[0].Offset != files[1].Offset || files[1].Offset != files[2].Offset {
+		t.Fatal("expected small files to share a compression block")
+	}
+
+	if files[2].Offset == files[3].Offset || files[3].Offset == files[4].Offset {
+		t.Fatal("expected a file overflowing the block to occupy a block of its own")
+	}
+
+	d, err := NewDecoder(bytes.NewReader(output.Bytes()), testArchiveKey, int64(output.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	if err := d.Extract(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, name := range names {
+		expectFile(t, filepath.Join(dir, name), contents[i])
+	}
+}
+
+func TestDecoderOpen(t *testing.T) {
+	archive, err := encodeArchive(WithBlockSize(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := NewDecoder(bytes.NewReader(archive), testArchiveKey, int64(len(archive)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := d.Open("some/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.Seek(4, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+
+	buf, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(buf) != "18th Century" {
+		t.Fatalf("expected %q got %q", "18th Century", buf)
+	}
+
+	r, err = d.Open("test2.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if buf, _ := io.ReadAll(r); string(buf) != "another file" {
+		t.Fatalf("expected %q got %q", "another file", buf)
+	}
+
+	if _, err := d.Open("missing.txt"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected not exist error got %v", err)
+	}
+}
+
+func expectFile(t *testing.T, path, contents string) {
+	t.Helper()
+
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(buf) != contents {
+		t.Fatalf("%s: expected %q got %q", path, contents, buf)
+	}
+}
+
+func encodeArchive(opts ...Option) ([]byte, error) {
+	output := bytes.NewBuffer(nil)
+	archive, err := New(output, testArchiveKey, opts...)
 	if err != nil {
 		return nil, err
 	}
@@ -58,3 +175,99 @@ func encodeArchive() ([]byte, error) {
 
 	return output.Bytes(), nil
 }
+
+// testBodyStart returns the offset of the encrypted body
+func testBodyStart(t *testing.T, archive []byte) int64 {
+	t.Helper()
+
+	d, err := NewDecoder(bytes.NewReader(archive), testArchiveKey, int64(len(archive)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return d.bodyOffset
+}
+
+func TestVerify(t *testing.T) {
+	archive, err := encodeArchive()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := NewDecoder(bytes.NewReader(archive), testArchiveKey, int64(len(archive)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	// modify the first byte of the body
+	archive[testBodyStart(t, archive)] ^= 1
+
+	d, err = NewDecoder(bytes.NewReader(archive), testArchiveKey, int64(len(archive)), WithVerification())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.Verify(); err != ErrIntegrityFailed {
+		t.Fatalf("expected %q got %v", ErrIntegrityFailed, err)
+	}
+
+	dir := t.TempDir()
+	if err := d.Extract(dir); err != ErrIntegrityFailed {
+		t.Fatalf("expected %q got %v", ErrIntegrityFailed, err)
+	}
+
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Fatal("expected no files to be extracted")
+	}
+}
+
+func TestTamperedBlock(t *testing.T) {
+	archive, err := encodeArchive()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// modify the compressed block
+	archive[testBodyStart(t, archive)+2] ^= 1
+
+	d, err := NewDecoder(bytes.NewReader(archive), testArchiveKey, int64(len(archive)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := d.Open("test.txt"); err != ErrIntegrityFailed {
+		t.Fatalf("expected %q got %v", ErrIntegrityFailed, err)
+	}
+}
+
+func TestTamperedAlmanac(t *testing.T) {
+	archive, err := encodeArchive()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := NewDecoder(bytes.NewReader(archive), testArchiveKey, int64(len(archive)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.open(); err != nil {
+		t.Fatal(err)
+	}
+
+	// modify the first byte of the almanac
+	archive[d.bodyOffset+int64(d.almanacStart)] ^= 1
+
+	d, err = NewDecoder(bytes.NewReader(archive), testArchiveKey, int64(len(archive)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := d.Almanac(); err != ErrIntegrityFailed {
+		t.Fatalf("expected %q got %v", ErrIntegrityFailed, err)
+	}
+}
@@ -3,126 +3,291 @@ package zar
 import (
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/ed25519"
 	"crypto/hmac"
 	"crypto/rand"
 	"crypto/sha512"
 	"encoding/binary"
-	"fmt"
 	"hash"
 	"io"
 
 	"github.com/andybalholm/brotli"
-	"github.com/dchest/siphash"
-	"golang.org/x/crypto/argon2"
-	"golang.org/x/crypto/hkdf"
 )
 
 // Encoder writes the archive
 type Encoder struct {
 	w io.Writer
 
-	// k1 is the key used for encryption and HMACs
-	k1, k2, k3 []byte
-	// salt is used in the key KDF
-	salt    []byte
+	header Header
+	keys   *archiveKeys
+	// nonce is used by the cipher
+	nonce   []byte
 	almanac []File
 
-	stream *streamCipher
-
+	// kdf are the parameters of the key passed to New
+	kdf KDFParams
+	// signingKey signs the archive when set, mac and almanacHash are the
+	// signed values which are set by Close
+	signingKey  ed25519.PrivateKey
+	mac         []byte
+	almanacHash []byte
+
+	// passwords are the additional key slots
+	passwords []slotPassword
+	// recipients are the X25519 public keys to seal the data key for
+	recipients [][]byte
+	// hybridRecipients are the X25519 and ML-KEM-768 public keys to seal
+	// the data key for
+	hybridRecipients [][]byte
+	// shareThreshold of shareCount shares unlock the archive when set, the
+	// shares are created by New
+	shareThreshold int
+	shareCount     int
+	shares         []string
+	// keyfiles is the digest of the keyfiles mixed into every password
+	keyfiles []byte
+
+	stream    *streamCipher
+	masterMac hash.Hash
+	// chunks seals the body when a chunked cipher suite is used
+	chunks *chunkWriter
+	// sealed seals the open compression block, or the almanac, with its own
+	// key when files have their own keys
+	sealed *chunkWriter
+
+	// brotilW compresses the currently open compression block, it is nil
+	// when no block is open
 	brotilW *brotli.Writer
-	// fileMac is used to hash the individual files in the repo
-	fileMac hash.Hash
+	// compressed counts the compressed length of the open block
+	compressed *countWriter
+	// frames splits the compressed block into frames when streaming
+	frames *frameWriter
+
+	// blockOffset is the offset of the open compression block from the
+	// start of the encrypted body
+	blockOffset uint64
+	// blockLen is the uncompressed length of the open compression block
+	blockLen uint64
+	// blockSize is the target uncompressed size of a compression block
+	blockSize uint64
+
+	// blockPadding is the padding policy of the compression blocks
+	blockPadding uint8
+	// bucket pads the body and trailer to a multiple of its size when set
+	bucket uint64
+
+	// entry is the file currently being written, only one may be open at
+	// a time
+	entry *entryWriter
+	// failed is set once writing an entry has failed
+	failed bool
 
 	compressionLevel int
 	note             []byte
-	cipherBlockSize  uint64
 }
 
-// New creates a new ZAR encoder
-func New(w io.Writer, key []byte) (*Encoder, error) {
-	// TODO: write header
-	// TODO: accept options; KDF, MAC, HKDF CHF
+// New creates a new ZAR encoder.
+//
+// The archive can be opened with the password key. If key is nil no password
+// slot is created and the archive can only be opened with the key slots added
+// by WithKeySlot, WithRecipient, WithHybridRecipient or WithShares.
+func New(w io.Writer, key []byte, opts ...Option) (*Encoder, error) {
+	// TODO: accept options; MAC, HKDF CHF
+
+	e := &Encoder{
+		w:                w,
+		header:           defaultHeader(),
+		blockSize:        DefaultBlockSize,
+		compressionLevel: brotli.DefaultCompression,
+		kdf:              KDFInteractive,
+	}
 
-	// generate salt/IV for KDF and AES
-	salt := make([]byte, aes.BlockSize)
-	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
-		return nil, err
+	for _, opt := range opts {
+		if err := opt(e); err != nil {
+			return nil, err
+		}
 	}
 
-	// write salt to file
-	if _, err := w.Write(salt); err != nil {
-		return nil, err
+	if e.header.RequiredFeatures.Has(FeatureFileKeys | FeatureStreaming) {
+		return nil, ErrFileKeysStreaming
 	}
 
-	// Run the key through Argon2Key KDF
-	k1 := argon2.Key(key, salt, 1, 20, 1, 32)
+	passwords := e.passwords
+	if key != nil {
+		passwords = append([]slotPassword{{key, e.kdf}}, passwords...)
+	}
 
-	// derive additional keys from master
-	kdf := hkdf.New(sha512.New, k1, nil, nil)
+	slotCount := len(passwords) + len(e.recipients) + len(e.hybridRecipients)
+	if e.shareThreshold > 0 {
+		slotCount++
+	}
 
-	// k2 is used for the master mac
-	k2 := make([]byte, 32)
-	// k3 is used for SipHash
-	k3 := make([]byte, 32)
-	// k4 is used for encryption
-	k4 := make([]byte, 32)
+	if slotCount == 0 || slotCount > MaxKeySlots || len(passwords) > MaxPasswordSlots {
+		return nil, ErrKeySlots
+	}
 
-	if _, err := io.ReadFull(kdf, k2); err != nil {
+	header := e.header.Marshal()
+	if _, err := w.Write(header); err != nil {
 		return nil, err
 	}
 
-	if _, err := io.ReadFull(kdf, k3); err != nil {
+	// generate the data key and the nonce for the cipher
+	dataKey := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
 		return nil, err
 	}
 
-	if _, err := io.ReadFull(kdf, k4); err != nil {
+	nonce := make([]byte, nonceSize)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
 		return nil, err
 	}
 
-	masterMac := hmac.New(sha512.New, k2)
+	// seal the data key for each password and recipient
+	var slots []KeySlot
+	for _, p := range passwords {
+		password := p.password
+		if e.keyfiles != nil {
+			password = mixKeyfiles(password, e.keyfiles)
+		}
 
-	// Create new AES_256 cipher
-	block, err := aes.NewCipher(k4)
+		slot, err := newPasswordSlot(password, dataKey, p.kdf, header)
+		if err != nil {
+			return nil, err
+		}
+
+		slots = append(slots, slot)
+	}
+
+	for _, publicKey := range e.recipients {
+		slot, err := newX25519Slot(publicKey, dataKey, header)
+		if err != nil {
+			return nil, err
+		}
+
+		slots = append(slots, slot)
+	}
+
+	for _, publicKey := range e.hybridRecipients {
+		slot, err := newHybridSlot(publicKey, dataKey, header)
+		if err != nil {
+			return nil, err
+		}
+
+		slots = append(slots, slot)
+	}
+
+	if e.shareThreshold > 0 {
+		shareKey := make([]byte, dataKeySize)
+		if _, err := io.ReadFull(rand.Reader, shareKey); err != nil {
+			return nil, err
+		}
+
+		slot, err := newShareSlot(shareKey, dataKey, e.shareThreshold, header)
+		if err != nil {
+			return nil, err
+		}
+
+		slots = append(slots, slot)
+
+		if e.shares, err = splitShares(shareKey, e.shareThreshold, e.shareCount); err != nil {
+			return nil, err
+		}
+	}
+
+	// write key slots and nonce to file
+	if _, err := w.Write(marshalKeySlots(slots)); err != nil {
+		return nil, err
+	}
+
+	if _, err := w.Write(nonce); err != nil {
+		return nil, err
+	}
+
+	keys, err := deriveKeys(dataKey, nonce, &e.header)
 	if err != nil {
 		return nil, err
 	}
 
-	if _, err := w.Write(salt); err != nil {
+	masterMac := hmac.New(sha512.New, keys.mac)
+
+	e.keys = keys
+	e.masterMac = masterMac
+	e.nonce = nonce
+
+	if chunkedSuite(e.header.CipherSuite) {
+		aead, err := newAEAD(e.header.CipherSuite, keys.cipher)
+		if err != nil {
+			return nil, err
+		}
+
+		// stream output -> AEAD chunks -> file
+		//                              -> master MAC
+		e.chunks = newChunkWriter(io.MultiWriter(w, masterMac), aead, nonce)
+		stream := newCipher(nil, nil, e.chunks, nil)
+		e.stream = &stream
+
+		return e, nil
+	}
+
+	// Create new AES_256 cipher
+	block, err := aes.NewCipher(keys.cipher)
+	if err != nil {
 		return nil, err
 	}
 
 	// Set mode to CTR
-	c := cipher.NewCTR(block, salt)
+	c := cipher.NewCTR(block, nonce[:block.BlockSize()])
 
 	// stream output to file
 	stream := newCipher(masterMac, nil, w, c)
+	e.stream = &stream
 
-	return &Encoder{
-		w:  w,
-		k1: k1,
-		k2: k2,
-		k3: k3,
-
-		stream:           &stream,
-		salt:             salt,
-		compressionLevel: brotli.DefaultCompression,
-
-		brotilW: brotli.NewWriterLevel(&stream, brotli.DefaultCompression),
-		fileMac: siphash.New(k3),
-
-		cipherBlockSize: uint64(block.BlockSize()),
-	}, nil
+	return e, nil
 }
 
 // Close must be called to finalise the archive
 func (e *Encoder) Close() error {
-	// e.closeBlock()
+	if e.failed {
+		return ErrEntryFailed
+	}
+
+	if e.entry != nil {
+		return ErrEntryOpen
+	}
+
+	if err := e.closeBlock(); err != nil {
+		return err
+	}
+
 	return e.writeAlmanac()
 }
 
 func (e *Encoder) writeAlmanac() error {
+	if e.header.RequiredFeatures.Has(FeatureStreaming) {
+		if err := writeFrame(e.stream, frameAlmanac, nil); err != nil {
+			return err
+		}
+	}
+
 	almanacOffset := e.stream.size
-	w := brotli.NewWriterLevel(e.stream, e.compressionLevel)
+	e.stream.tag = newTag(e.keys.almanac, almanacOffset)
+
+	// the compressed almanac is hashed before it is encrypted for the
+	// signature
+	almanacHash := sha512.New()
+	var dst io.Writer = e.stream
+	if e.header.RequiredFeatures.Has(FeatureFileKeys) {
+		aead, err := newAEAD(fileSuite(&e.header), e.keys.almanacKey)
+		if err != nil {
+			return err
+		}
+
+		e.sealed = newChunkWriter(e.stream, aead, nil)
+		dst = e.sealed
+	}
+
+	compressed := &countWriter{w: io.MultiWriter(almanacHash, dst)}
+	w := brotli.NewWriterLevel(compressed, e.compressionLevel)
 
 	// write array size of almanac
 	fileCount := make([]byte, 8)
@@ -131,8 +296,6 @@ func (e *Encoder) writeAlmanac() error {
 		return err
 	}
 
-	e.fileMac.Write(fileCount)
-
 	buf := make([]byte, 8)
 	for i := 0; i < len(e.almanac); i++ {
 		// write block offset
@@ -141,43 +304,28 @@ func (e *Encoder) writeAlmanac() error {
 			return err
 		}
 
-		// compute message authentication code
-		e.fileMac.Write(buf)
-
 		// write file size
 		binary.BigEndian.PutUint64(buf, e.almanac[i].Size)
 		if _, err := w.Write(buf); err != nil {
 			return err
 		}
 
-		// compute message authentication code
-		e.fileMac.Write(buf)
-
 		// write modified date
 		binary.BigEndian.PutUint64(buf, e.almanac[i].Modified)
 		if _, err := w.Write(buf); err != nil {
 			return err
 		}
 
-		// compute message authentication code
-		e.fileMac.Write(buf)
-
 		// write file name length
 		binary.BigEndian.PutUint16(buf, uint16(len(e.almanac[i].Name)))
 		if _, err := w.Write(buf[:2]); err != nil {
 			return err
 		}
 
-		// compute message authentication code
-		e.fileMac.Write(buf[:2])
-
 		// write file name
 		if _, err := w.Write([]byte(e.almanac[i].Name)); err != nil {
 			return err
 		}
-
-		// compute message authentication code
-		e.fileMac.Write([]byte(e.almanac[i].Name))
 	}
 
 	// write note length
@@ -186,26 +334,28 @@ func (e *Encoder) writeAlmanac() error {
 		return err
 	}
 
-	// compute message authentication code
-	e.fileMac.Write(buf[:2])
-
 	// write note
 	if _, err := w.Write(e.note); err != nil {
 		return err
 	}
 
-	// compute message authentication code
-	e.fileMac.Write(e.note)
-
-	// write almanac mac
-	if _, err := w.Write([]byte(e.fileMac.Sum(nil))); err != nil {
+	// finalise compression
+	if err := w.Close(); err != nil {
 		return err
 	}
 
-	e.fileMac.Reset()
+	if e.bucket > 0 {
+		if err := writePadding(compressed, e.bucketPaddingSize(almanacOffset, compressed.n)); err != nil {
+			return err
+		}
+	}
 
-	// finalise compression
-	if err := w.Close(); err != nil {
+	if err := e.closeSealed(); err != nil {
+		return err
+	}
+
+	// authenticate the almanac's ciphertext
+	if err := e.writeTag(); err != nil {
 		return err
 	}
 
@@ -216,57 +366,171 @@ func (e *Encoder) writeAlmanac() error {
 	}
 
 	// pad ciphertext
-	padding := pkcs5(e.stream.size, e.cipherBlockSize)
+	padding := pkcs5(e.stream.size, padBlockSize)
 	if _, err := e.stream.Write(padding); err != nil {
 		return err
 	}
 
+	// seal the final chunk
+	if e.chunks != nil {
+		if err := e.chunks.Close(); err != nil {
+			return err
+		}
+	}
+
 	// append EtM master Mac
-	if _, err := e.w.Write(e.stream.MAC()); err != nil {
+	e.mac = e.masterMac.Sum(nil)
+	e.almanacHash = almanacHash.Sum(nil)
+	if _, err := e.w.Write(e.mac); err != nil {
 		return err
 	}
 
+	// append signature trailer
+	if e.signingKey != nil {
+		sig, err := e.Sign(e.signingKey)
+		if err != nil {
+			return err
+		}
+
+		if _, err := e.w.Write(sig); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
-// Add will read a file and add it to the archive
+// Add will read a file and add it to the archive.
+//
+// The file is appended to the open compression block, which is sealed once
+// its uncompressed size reaches the target block size. If reading the file
+// fails the archive can not be completed and Close returns ErrEntryFailed.
 func (e *Encoder) Add(name string, modified uint64, r io.Reader) (int64, error) {
+	w, err := e.Create(name, modified)
+	if err != nil {
+		return 0, err
+	}
+
+	// stream file -> compressor -> AES -> output file
+	//                                -> block tag
+	n, err := io.Copy(w, r)
+	if err != nil {
+		// the partial file is left out of the almanac and the archive can
+		// no longer be closed
+		e.abort()
+		return n, err
+	}
 
-	// will be used to calculate the size of the Brotil output
-	sizeBefore := e.stream.size
-	fmt.Printf("BEFORE === %-16s %s: %d\n", "Stream Size", name, sizeBefore)
+	return n, w.Close()
+}
+
+// openBlock starts a new compression block at the current position of the
+// ciphertext stream
+func (e *Encoder) openBlock() {
+	e.blockOffset = e.stream.size
+	e.blockLen = 0
+	e.stream.tag = newTag(e.keys.block, e.blockOffset)
 
 	// create new brotil compressor which directs output into AES_256_CTR
 	// stream
-	brotilW := brotli.NewWriterLevel(e.stream, e.compressionLevel)
+	if e.header.RequiredFeatures.Has(FeatureStreaming) {
+		e.frames = newFrameWriter(e.stream)
+		e.compress(e.frames)
+		return
+	}
 
-	// stream file -> compressor -> AES -> output file
-	//             -> SipHash
-	n, err := io.Copy(io.MultiWriter(brotilW, e.fileMac), r)
+	e.compress(e.stream)
+}
+
+// compress directs the compressed output of the open block to w
+func (e *Encoder) compress(w io.Writer) {
+	e.compressed = &countWriter{w: w}
+	e.brotilW = brotli.NewWriterLevel(e.compressed, e.compressionLevel)
+}
+
+// openFileBlock starts a compression block holding only the file, sealed
+// with the file's key
+func (e *Encoder) openFileBlock(f *File) error {
+	e.blockOffset = e.stream.size
+	e.blockLen = 0
+	e.stream.tag = newTag(e.keys.block, e.blockOffset)
+
+	f.Offset = e.blockOffset
+	aead, err := e.keys.fileAEAD(&e.header, f)
 	if err != nil {
-		return n, err
+		return err
+	}
+
+	e.sealed = newChunkWriter(e.stream, aead, nil)
+	e.compress(e.sealed)
+	return nil
+}
+
+// closeSealed seals the final chunk of a section sealed with its own key, it
+// is a no-op if the section is not sealed
+func (e *Encoder) closeSealed() error {
+	if e.sealed == nil {
+		return nil
 	}
 
-	mac := e.fileMac.Sum(nil)
-	fmt.Printf("MAC %X\n", mac)
+	err := e.sealed.Close()
+	e.sealed = nil
+	return err
+}
 
-	// Sum MAC and compress it with block
-	brotilW.Write(mac)
-	e.fileMac.Reset()
+// closeBlock seals the open compression block by flushing the compressor and
+// appending the tag of the block's ciphertext. It is a no-op if no block is
+// open.
+func (e *Encoder) closeBlock() error {
+	if e.brotilW == nil {
+		return nil
+	}
 
-	if err := brotilW.Close(); err != nil {
-		return 0, err
+	if err := e.brotilW.Close(); err != nil {
+		return err
 	}
 
-	e.almanac = append(e.almanac, File{
-		Name:     name,
-		Modified: modified,
-		Size:     e.stream.size - sizeBefore,
-		Offset:   sizeBefore, //TODO: calculate bytes from block start
-	})
+	e.brotilW = nil
+
+	if err := writePadding(e.compressed, e.blockPaddingSize(e.compressed.n)); err != nil {
+		return err
+	}
 
-	fmt.Printf("AFTER  === %-16s %s: %d\n", "Stream Size", name, e.stream.size)
-	fmt.Printf("AFTER  === %-16s %s: %d\n", "Offset", name, e.stream.size-sizeBefore)
+	if err := e.closeSealed(); err != nil {
+		return err
+	}
+
+	if e.frames != nil {
+		if err := e.closeFrames(); err != nil {
+			return err
+		}
+	}
+
+	return e.writeTag()
+}
+
+// writeTag ends the current section of ciphertext by writing its tag
+func (e *Encoder) writeTag() error {
+	tag := e.stream.tag.Sum(nil)
+	e.stream.tag = nil
+
+	_, err := e.stream.Write(tag)
+	return err
+}
+
+// closeFrames flushes the block's data frames and writes its end frame
+// listing the files stored within the block
+func (e *Encoder) closeFrames() error {
+	if err := e.frames.Flush(); err != nil {
+		return err
+	}
+
+	e.frames = nil
+
+	first := len(e.almanac)
+	for first > 0 && e.almanac[first-1].Offset == e.blockOffset {
+		first--
+	}
 
-	return n, nil
+	return writeFrame(e.stream, frameEnd, marshalEntries(e.almanac[first:]))
 }
@@ -17,19 +17,26 @@ func TestEncode(t *testing.T) {
 	}
 
 	buf := bytes.NewBuffer([]byte("my file contents..."))
-	n, err := archive.Add("/test.txt", 0, buf)
+	n, err := archive.Add("test.txt", 0, buf)
 	if err != nil {
-		fmt.Println(n, err)
+		t.Fatal(err)
 	}
 
 	fmt.Println(n)
 	buf.Write([]byte("oasasjdoasidjdojasoidfoisjdfoi"))
-	n, err = archive.Add("/asoidjfoiasjdfoi.txt", 0, buf)
+	n, err = archive.Add("asoidjfoiasjdfoi.txt", 0, buf)
 	if err != nil {
-		fmt.Println(n, err)
+		t.Fatal(err)
 	}
 
-	archive.Close()
+	fmt.Println(n)
+	if _, err := archive.Add("/absolute.txt", 0, bytes.NewBufferString("contents")); err != zar.ErrFileName {
+		t.Fatalf("expected %q got %v", zar.ErrFileName, err)
+	}
+
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
 
 	fmt.Printf("%X\n", output)
 }
@@ -0,0 +1,181 @@
+package zar
+
+import (
+	"errors"
+	"io"
+	"math"
+)
+
+var (
+	// ErrEntryOpen is returned when a file is added or the archive is closed
+	// while an entry returned by Create has not been closed
+	ErrEntryOpen = errors.New("previous entry has not been closed")
+	// ErrEntryClosed is returned when writing to an entry which has been
+	// closed
+	ErrEntryClosed = errors.New("entry has been closed")
+	// ErrFileNameLength is returned when a file name is longer than
+	// MaxFileNameLength bytes
+	ErrFileNameLength = errors.New("file name too long")
+	// ErrEntryFailed is returned by Create and Close once writing a file has
+	// failed, as the partial file can not be removed from its compression
+	// block and the archive can not be completed
+	ErrEntryFailed = errors.New("archive is incomplete as a file failed to be written")
+)
+
+// MaxFileNameLength is the longest file name, in bytes, which can be stored
+// in the almanac
+const MaxFileNameLength = math.MaxUint16
+
+// entryWriter writes a single file into the open compression block
+type entryWriter struct {
+	e        *Encoder
+	name     string
+	modified uint64
+	// size is the amount of bytes written to the entry
+	size   uint64
+	closed bool
+
+	// buffered is set while the start of the file is held in pending, as
+	// the file is moved to a new block if it would overflow the open one
+	buffered bool
+	pending  []byte
+}
+
+// Create adds a file to the archive and returns a writer for its contents,
+// the file is recorded in the almanac once the writer is closed.
+//
+// Only one entry may be open at a time, the writer must be closed before
+// another file is added or the archive is closed. ErrFileName is returned if
+// the name would be rejected on extraction.
+//
+// If a write to the entry fails the archive can not be completed and Close
+// returns ErrEntryFailed.
+func (e *Encoder) Create(name string, modified uint64) (io.WriteCloser, error) {
+	if e.failed {
+		return nil, ErrEntryFailed
+	}
+
+	if e.entry != nil {
+		return nil, ErrEntryOpen
+	}
+
+	if len(name) > MaxFileNameLength {
+		return nil, ErrFileNameLength
+	}
+
+	if !validateName(name) {
+		return nil, ErrFileName
+	}
+
+	if e.header.RequiredFeatures.Has(FeatureFileKeys) {
+		// every file has a block of its own sealed with the file's key
+		if err := e.openFileBlock(&File{Name: name, Modified: modified}); err != nil {
+			return nil, err
+		}
+	} else if e.brotilW == nil {
+		e.openBlock()
+	}
+
+	e.entry = &entryWriter{
+		e:        e,
+		name:     name,
+		modified: modified,
+		buffered: e.blockLen > 0,
+	}
+
+	return e.entry, nil
+}
+
+func (w *entryWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, ErrEntryClosed
+	}
+
+	e := w.e
+	if w.buffered {
+		if e.blockLen+w.size+uint64(len(p)) <= e.blockSize {
+			w.pending = append(w.pending, p...)
+			w.size += uint64(len(p))
+			return len(p), nil
+		}
+
+		if err := w.spill(); err != nil {
+			e.abort()
+			return 0, err
+		}
+	}
+
+	n, err := e.brotilW.Write(p)
+	w.size += uint64(n)
+
+	if err != nil {
+		e.abort()
+	}
+
+	return n, err
+}
+
+// abort discards the open entry after a failed write. The encoder refuses to
+// add further files or close, as the partial file remains in its block.
+func (e *Encoder) abort() {
+	if e.entry != nil {
+		e.entry.closed = true
+		e.entry = nil
+	}
+
+	e.failed = true
+}
+
+// spill seals the open block, as the file would overflow it, and moves the
+// buffered start of the file into a new block
+func (w *entryWriter) spill() error {
+	e := w.e
+	w.buffered = false
+
+	if err := e.closeBlock(); err != nil {
+		return err
+	}
+
+	e.openBlock()
+
+	_, err := e.brotilW.Write(w.pending)
+	w.pending = nil
+
+	return err
+}
+
+// Close records the file in the almanac and seals the compression block if
+// it has reached the target size
+func (w *entryWriter) Close() error {
+	if w.closed {
+		return ErrEntryClosed
+	}
+
+	w.closed = true
+	e := w.e
+	e.entry = nil
+
+	// the file fits within the open block
+	if len(w.pending) > 0 {
+		if _, err := e.brotilW.Write(w.pending); err != nil {
+			e.failed = true
+			return err
+		}
+
+		w.pending = nil
+	}
+
+	e.almanac = append(e.almanac, File{
+		Name:     w.name,
+		Modified: w.modified,
+		Size:     w.size,
+		Offset:   e.blockOffset,
+	})
+
+	e.blockLen += w.size
+	if e.blockLen >= e.blockSize || e.header.RequiredFeatures.Has(FeatureFileKeys) {
+		return e.closeBlock()
+	}
+
+	return nil
+}
@@ -0,0 +1,197 @@
+package zar
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestCreate(t *testing.T) {
+	output := bytes.NewBuffer(nil)
+	archive, err := New(output, testArchiveKey, WithBlockSize(32))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := archive.Create("dump.sql", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 10; i++ {
+		fmt.Fprintf(w, "INSERT INTO t VALUES (%d);\n", i)
+	}
+
+	if _, err := archive.Create("other.txt", 0); err != ErrEntryOpen {
+		t.Fatalf("expected %q got %v", ErrEntryOpen, err)
+	}
+
+	if err := archive.Close(); err != ErrEntryOpen {
+		t.Fatalf("expected %q got %v", ErrEntryOpen, err)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := w.Write([]byte("late")); err != ErrEntryClosed {
+		t.Fatalf("expected %q got %v", ErrEntryClosed, err)
+	}
+
+	if _, err := archive.Add("other.txt", 0, bytes.NewBufferString("other")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := NewDecoder(bytes.NewReader(output.Bytes()), testArchiveKey, int64(output.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fsys, err := d.FS()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contents, err := fsys.ReadFile("dump.sql")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.HasPrefix(contents, []byte("INSERT INTO t VALUES (0);\n")) || len(contents) != 260 {
+		t.Fatalf("unexpected contents %q", contents)
+	}
+}
+
+func TestBlockOverflow(t *testing.T) {
+	output := bytes.NewBuffer(nil)
+	archive, err := New(output, testArchiveKey, WithBlockSize(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	large := bytes.Repeat([]byte("large file "), 30000)
+	for _, f := range []struct {
+		name     string
+		contents []byte
+	}{
+		{"small.txt", []byte("small file")},
+		{"large.txt", large},
+		{"after.txt", []byte("after")},
+	} {
+		if _, err := archive.Add(f.name, 0, bytes.NewReader(f.contents)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files := archive.almanac
+	if files[0].Offset == files[1].Offset || files[1].Offset == files[2].Offset {
+		t.Fatalf("expected the large file to occupy a block of its own %+v", files)
+	}
+
+	d, err := NewDecoder(bytes.NewReader(output.Bytes()), testArchiveKey, int64(output.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := d.Open("large.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	contents := new(bytes.Buffer)
+	if _, err := contents.ReadFrom(f); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(contents.Bytes(), large) {
+		t.Fatal("large file contents do not match")
+	}
+}
+
+// failingReader returns err after reading n bytes
+type failingReader struct {
+	n   int
+	err error
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if r.n == 0 {
+		return 0, r.err
+	}
+
+	if len(p) > r.n {
+		p = p[:r.n]
+	}
+
+	r.n -= len(p)
+	return len(p), nil
+}
+
+func TestCreateFailed(t *testing.T) {
+	archive, err := New(bytes.NewBuffer(nil), testArchiveKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := archive.Add("ok.txt", 0, bytes.NewBufferString("ok")); err != nil {
+		t.Fatal(err)
+	}
+
+	readErr := errors.New("read failed")
+	if _, err := archive.Add("bad.txt", 0, &failingReader{n: 7, err: readErr}); err != readErr {
+		t.Fatalf("expected %q got %v", readErr, err)
+	}
+
+	if len(archive.almanac) != 1 {
+		t.Fatalf("expected the failed file to be left out of the almanac %+v", archive.almanac)
+	}
+
+	if _, err := archive.Create("other.txt", 0); err != ErrEntryFailed {
+		t.Fatalf("expected %q got %v", ErrEntryFailed, err)
+	}
+
+	if err := archive.Close(); err != ErrEntryFailed {
+		t.Fatalf("expected %q got %v", ErrEntryFailed, err)
+	}
+}
+
+func TestCreateName(t *testing.T) {
+	archive, err := New(bytes.NewBuffer(nil), testArchiveKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, expected := range map[string]error{
+		strings.Repeat("a", MaxFileNameLength+1): ErrFileNameLength,
+		"/etc/passwd":                            ErrFileName,
+		"../escape.txt":                          ErrFileName,
+		"":                                       ErrFileName,
+	} {
+		if _, err := archive.Create(name, 0); err != expected {
+			t.Fatalf("expected %q got %v", expected, err)
+		}
+	}
+
+	w, err := archive.Create(strings.Repeat("a", MaxFileNameLength), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
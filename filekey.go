@@ -0,0 +1,303 @@
+package zar
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+
+	"github.com/andybalholm/brotli"
+	"golang.org/x/crypto/hkdf"
+)
+
+// File keys
+//
+// Archives written with WithFileKeys store every file in a compression block
+// of its own. The compressed block is sealed with a key derived from the data
+// key and the file's identity before it is encrypted with the body cipher,
+// and the almanac is sealed in the same way with a key of its own:
+//
+//	File Key = HKDF-Expand(PRK, "zar file key" || 0 || Header ||
+//	    Offset (8 bytes) || Modified (8 bytes) || Name)
+//
+// The sealed contents use the chunked construction of the body with a zero
+// nonce prefix, as every key seals a single section. The AEAD is that of the
+// archive's cipher suite, or AES-256-GCM for CipherAES256CTR.
+//
+// The body cipher then only hides the layout of the archive, so a capability
+// token can carry its key along with one file's key to decrypt and verify
+// that file and nothing else. The block tags can not be checked without the
+// archive's keys, the holder of a token relies on the file's AEAD instead.
+//
+//	Capability Token
+//	    - Magic Number "ZCAP"
+//	    - Header (16 bytes)
+//	    - Nonce (24 bytes)
+//	    - Body Offset (8 bytes)
+//	    - Body Length (8 bytes)
+//	    - Body Key (32 bytes)
+//	    - File Key (32 bytes)
+//	    - Block Length (8 bytes)
+//	    - Offset (8 bytes)
+//	    - Size (8 bytes)
+//	    - Modified (8 bytes)
+//	    - Name Length (2 bytes)
+//	    - Name
+
+const (
+	// FeatureFileKeys marks an archive as sealing each file with a key of
+	// its own
+	FeatureFileKeys Feature = 1 << 3
+)
+
+const (
+	labelFileKey = "zar file key"
+
+	capabilityMagic = "ZCAP"
+	// capabilitySize is the length of a capability token without the name
+	capabilitySize = len(capabilityMagic) + HeaderSize + nonceSize + 8 + 8 + 32 + 32 + 8 + 8 + 8 + 8 + 2
+)
+
+var (
+	// ErrNoFileKeys is returned when exporting a capability from an archive
+	// not written with WithFileKeys
+	ErrNoFileKeys = errors.New("archive does not have file keys")
+	// ErrFileKeysStreaming is returned when WithFileKeys is combined with
+	// WithStreaming, as the end frames would reveal the names of the files
+	ErrFileKeysStreaming = errors.New("file keys can not be used with streaming")
+	// ErrCapability is returned when a capability token is malformed
+	ErrCapability = errors.New("invalid capability token")
+)
+
+// WithFileKeys seals each file with a key of its own, which allows a
+// capability for a single file to be exported with Decoder.Capability.
+// Every file is stored in a compression block of its own so WithBlockSize
+// has no effect.
+func WithFileKeys() Option {
+	return func(e *Encoder) error {
+		e.header.RequiredFeatures |= FeatureFileKeys
+		return nil
+	}
+}
+
+// fileSuite returns the cipher suite sealing the files and almanac
+func fileSuite(header *Header) uint8 {
+	if chunkedSuite(header.CipherSuite) {
+		return header.CipherSuite
+	}
+
+	return CipherAES256GCM
+}
+
+// fileKey derives the key sealing the file's compression block
+func (k *archiveKeys) fileKey(header *Header, f *File) ([]byte, error) {
+	identity := make([]byte, 16)
+	binary.BigEndian.PutUint64(identity, f.Offset)
+	binary.BigEndian.PutUint64(identity[8:], f.Modified)
+
+	info := append(append([]byte(labelFileKey), 0), header.Marshal()...)
+	info = append(append(info, identity...), f.Name...)
+
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.Expand(sha512.New, k.prk, info), key); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// fileAEAD returns the AEAD sealing the file's compression block
+func (k *archiveKeys) fileAEAD(header *Header, f *File) (cipher.AEAD, error) {
+	key, err := k.fileKey(header, f)
+	if err != nil {
+		return nil, err
+	}
+
+	return newAEAD(fileSuite(header), key)
+}
+
+// openFileBlock authenticates and decompresses a compression block sealed
+// with its file's key, size is the uncompressed length of the block. The
+// block grows as it is decompressed, as the size of a capability is not
+// authenticated.
+func openFileBlock(aead cipher.AEAD, sealed []byte, size uint64) (Block, error) {
+	chunks := newChunkReader(bytes.NewReader(sealed), aead, nil)
+
+	if size > math.MaxInt64 {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	block, err := io.ReadAll(io.LimitReader(brotli.NewReader(chunks), int64(size)))
+	if err != nil {
+		return nil, err
+	}
+
+	if uint64(len(block)) != size {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	// read to the end so the final chunk is authenticated
+	if _, err := io.Copy(io.Discard, chunks); err != nil {
+		return nil, err
+	}
+
+	return block, nil
+}
+
+// Capability grants access to a single file of an archive written with
+// WithFileKeys. Along with the file's key it carries the body key, which is
+// shared by the whole archive: a holder can remove the outer encryption of
+// the entire body and so learns the archive's layout, such as the offset and
+// length of the almanac and of each sealed block. The other files and the
+// almanac stay sealed with keys the capability does not hold.
+type Capability struct {
+	// File locates the file within the archive
+	File File
+
+	header      Header
+	nonce       []byte
+	bodyOffset  int64
+	bodyLength  int64
+	bodyKey     []byte
+	fileKey     []byte
+	blockLength uint64
+}
+
+// Capability exports a capability for the named file, which can be given to
+// a third party to decrypt and verify that file alone
+func (d *Decoder) Capability(name string) (*Capability, error) {
+	if !d.header.RequiredFeatures.Has(FeatureFileKeys) {
+		return nil, ErrNoFileKeys
+	}
+
+	if err := d.open(); err != nil {
+		return nil, err
+	}
+
+	id, ok := d.names[name]
+	if !ok {
+		return nil, ErrFileNotFound
+	}
+
+	files := d.almanac.Files
+	key, err := d.keys.fileKey(d.header, &files[id])
+	if err != nil {
+		return nil, err
+	}
+
+	return &Capability{
+		File:        files[id],
+		header:      *d.header,
+		nonce:       d.nonce,
+		bodyOffset:  d.bodyOffset,
+		bodyLength:  d.size - d.bodyOffset - d.trailerSize(),
+		bodyKey:     d.keys.cipher,
+		fileKey:     key,
+		blockLength: d.blockLength(files, id),
+	}, nil
+}
+
+// Marshal encodes the capability as a token
+func (c *Capability) Marshal() []byte {
+	buf := make([]byte, capabilitySize, capabilitySize+len(c.File.Name))
+
+	n := copy(buf, capabilityMagic)
+	n += copy(buf[n:], c.header.Marshal())
+	n += copy(buf[n:], c.nonce)
+
+	for _, x := range []uint64{uint64(c.bodyOffset), uint64(c.bodyLength)} {
+		binary.BigEndian.PutUint64(buf[n:], x)
+		n += 8
+	}
+
+	n += copy(buf[n:], c.bodyKey)
+	n += copy(buf[n:], c.fileKey)
+
+	for _, x := range []uint64{c.blockLength, c.File.Offset, c.File.Size, c.File.Modified} {
+		binary.BigEndian.PutUint64(buf[n:], x)
+		n += 8
+	}
+
+	binary.BigEndian.PutUint16(buf[n:], uint16(len(c.File.Name)))
+
+	return append(buf, c.File.Name...)
+}
+
+// ParseCapability decodes a capability token returned by Capability.Marshal
+func ParseCapability(token []byte) (*Capability, error) {
+	if len(token) < capabilitySize || string(token[:len(capabilityMagic)]) != capabilityMagic {
+		return nil, ErrCapability
+	}
+
+	buf := token[len(capabilityMagic):]
+	header, err := unmarshalHeader(buf[:HeaderSize])
+	if err != nil {
+		return nil, err
+	}
+
+	if !header.RequiredFeatures.Has(FeatureFileKeys) {
+		return nil, ErrNoFileKeys
+	}
+
+	buf = buf[HeaderSize:]
+	c := &Capability{header: *header}
+
+	c.nonce, buf = buf[:nonceSize], buf[nonceSize:]
+	c.bodyOffset = int64(binary.BigEndian.Uint64(buf))
+	c.bodyLength = int64(binary.BigEndian.Uint64(buf[8:]))
+	buf = buf[16:]
+
+	c.bodyKey, buf = buf[:32], buf[32:]
+	c.fileKey, buf = buf[:32], buf[32:]
+
+	c.blockLength = binary.BigEndian.Uint64(buf)
+	c.File.Offset = binary.BigEndian.Uint64(buf[8:])
+	c.File.Size = binary.BigEndian.Uint64(buf[16:])
+	c.File.Modified = binary.BigEndian.Uint64(buf[24:])
+
+	nameLength := int(binary.BigEndian.Uint16(buf[32:]))
+	buf = buf[34:]
+
+	if len(buf) != nameLength || c.bodyOffset < 0 || c.bodyLength < 0 || c.blockLength < BlockTagSize {
+		return nil, ErrCapability
+	}
+
+	c.File.Name = string(buf)
+	return c, nil
+}
+
+// Open decrypts, decompresses and authenticates the capability's file from
+// the archive r
+func (c *Capability) Open(r io.ReaderAt) (io.ReadSeeker, error) {
+	body, err := newBody(r, c.header.CipherSuite, c.bodyKey, c.nonce, c.bodyOffset, c.bodyLength)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.File.Offset+c.blockLength > uint64(body.size()) {
+		return nil, ErrCapability
+	}
+
+	raw := make([]byte, c.blockLength)
+	if err := body.readAt(raw, c.File.Offset); err != nil {
+		return nil, err
+	}
+
+	body.decrypt(raw, c.File.Offset)
+
+	aead, err := newAEAD(fileSuite(&c.header), c.fileKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// the block's tag is skipped as its key is not part of the capability
+	block, err := openFileBlock(aead, raw[:len(raw)-BlockTagSize], c.File.Size)
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.NewReader(block), nil
+}
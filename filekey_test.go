@@ -0,0 +1,139 @@
+package zar
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestFileKeys(t *testing.T) {
+	contents := map[string][]byte{
+		"a.txt": []byte("first file"),
+		"b.txt": bytes.Repeat([]byte("second file "), 20000),
+		"c.txt": []byte("third file"),
+	}
+
+	for _, suite := range []uint8{CipherAES256CTR, CipherAES256GCM, CipherXChaCha20Poly1305} {
+		output := bytes.NewBuffer(nil)
+		archive, err := New(output, testArchiveKey, WithFileKeys(), WithCipherSuite(suite))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, name := range []string{"a.txt", "b.txt", "c.txt"} {
+			if _, err := archive.Add(name, 10, bytes.NewReader(contents[name])); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := archive.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		buf := output.Bytes()
+		d, err := NewDecoder(bytes.NewReader(buf), testArchiveKey, int64(len(buf)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := d.Verify(); err != nil {
+			t.Fatal(err)
+		}
+
+		r, err := d.Reader()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(d.almanac.MAC) != BlockTagSize {
+			t.Fatalf("expected almanac tag of %d bytes got %d", BlockTagSize, len(d.almanac.MAC))
+		}
+
+		for {
+			f, err := r.Next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !bytes.Equal(data, contents[f.Name]) {
+				t.Fatalf("%s: contents do not match", f.Name)
+			}
+		}
+
+		c, err := d.Capability("b.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		token, err := ParseCapability(c.Marshal())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if token.File != c.File {
+			t.Fatalf("expected %+v got %+v", c.File, token.File)
+		}
+
+		f, err := token.Open(bytes.NewReader(buf))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data, err := io.ReadAll(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(data, contents["b.txt"]) {
+			t.Fatal("capability contents do not match")
+		}
+
+		// a token claiming a larger file fails without allocating its size
+		oversized := *token
+		oversized.File.Size = 1 << 62
+		if _, err := oversized.Open(bytes.NewReader(buf)); err != io.ErrUnexpectedEOF {
+			t.Fatalf("expected %q got %v", io.ErrUnexpectedEOF, err)
+		}
+
+		// the capability does not open the other files
+		other, err := d.Capability("c.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		token.File = other.File
+		token.blockLength = other.blockLength
+		if _, err := token.Open(bytes.NewReader(buf)); err != ErrIntegrityFailed {
+			t.Fatalf("expected %q got %v", ErrIntegrityFailed, err)
+		}
+
+		if _, err := ParseCapability(c.Marshal()[:capabilitySize]); err != ErrCapability {
+			t.Fatalf("expected %q got %v", ErrCapability, err)
+		}
+	}
+
+	plain, err := encodeArchive()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := NewDecoder(bytes.NewReader(plain), testArchiveKey, int64(len(plain)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := d.Capability("test.txt"); err != ErrNoFileKeys {
+		t.Fatalf("expected %q got %v", ErrNoFileKeys, err)
+	}
+
+	if _, err := New(bytes.NewBuffer(nil), testArchiveKey, WithFileKeys(), WithStreaming()); err != ErrFileKeysStreaming {
+		t.Fatalf("expected %q got %v", ErrFileKeysStreaming, err)
+	}
+}
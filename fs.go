@@ -0,0 +1,281 @@
+package zar
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	fsFilePermissions = 0444
+	fsDirPermissions  = 0555
+)
+
+// ErrIsDir is returned when a file operation is used on a directory
+var ErrIsDir = errors.New("is a directory")
+
+// FS is a read only file system containing the files of an archive.
+//
+// FS implements fs.FS, fs.ReadDirFS, fs.ReadFileFS and fs.StatFS. The
+// directory tree is built from the names in the almanac, directories are not
+// stored in the archive and exist only when they contain a file. A file
+// whose name is also the path of a directory is left out, it can still be
+// read with Decoder.Open.
+type FS struct {
+	d *Decoder
+
+	// files maps a path to the file's index in the almanac
+	files map[string]int
+	// dirs maps a directory's path to its sorted entries
+	dirs map[string][]fs.DirEntry
+}
+
+// FS returns a file system backed by the archive. The almanac is read and
+// authenticated before returning.
+func (d *Decoder) FS() (*FS, error) {
+	if err := d.open(); err != nil {
+		return nil, err
+	}
+
+	fsys := &FS{
+		d:     d,
+		files: make(map[string]int, len(d.almanac.Files)),
+		dirs:  map[string][]fs.DirEntry{".": nil},
+	}
+
+	names := make([]string, len(d.almanac.Files))
+	parents := make(map[string]bool)
+
+	for i, f := range d.almanac.Files {
+		name := path.Clean(strings.ReplaceAll(f.Name, "\\", "/"))
+		if !fs.ValidPath(name) || name == "." {
+			continue
+		}
+
+		names[i] = name
+		for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+			parents[dir] = true
+		}
+	}
+
+	for i, name := range names {
+		// skip files which clash with a directory
+		if name == "" || parents[name] {
+			continue
+		}
+
+		// the last file with a name wins, as with Decoder.Open
+		fsys.files[name] = i
+	}
+
+	for name, i := range fsys.files {
+		fsys.addEntry(name, fileInfo{file: &d.almanac.Files[i], name: name})
+	}
+
+	for _, entries := range fsys.dirs {
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].Name() < entries[j].Name()
+		})
+	}
+
+	return fsys, nil
+}
+
+// addEntry adds the entry to its parent directory, creating any missing
+// parent directories
+func (fsys *FS) addEntry(name string, info fileInfo) {
+	dir := path.Dir(name)
+
+	if _, ok := fsys.dirs[dir]; !ok {
+		fsys.dirs[dir] = nil
+		fsys.addEntry(dir, fileInfo{name: dir})
+	}
+
+	fsys.dirs[dir] = append(fsys.dirs[dir], fs.FileInfoToDirEntry(info))
+}
+
+// Open opens the named file or directory
+func (fsys *FS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	if entries, ok := fsys.dirs[name]; ok {
+		return &dir{info: fileInfo{name: name}, entries: entries}, nil
+	}
+
+	id, ok := fsys.files[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	contents, err := fsys.d.readFile(id)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	return &file{
+		Reader: bytes.NewReader(contents),
+		info:   fileInfo{file: &fsys.d.almanac.Files[id], name: name},
+	}, nil
+}
+
+// ReadFile returns the contents of the named file
+func (fsys *FS) ReadFile(name string) ([]byte, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: fs.ErrInvalid}
+	}
+
+	id, ok := fsys.files[name]
+	if !ok {
+		if _, ok := fsys.dirs[name]; ok {
+			return nil, &fs.PathError{Op: "readfile", Path: name, Err: ErrIsDir}
+		}
+
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: fs.ErrNotExist}
+	}
+
+	contents, err := fsys.d.readFile(id)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: err}
+	}
+
+	// the caller may modify the returned slice
+	return append([]byte(nil), contents...), nil
+}
+
+// ReadDir returns the sorted entries of the named directory
+func (fsys *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+
+	entries, ok := fsys.dirs[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+
+	return append([]fs.DirEntry(nil), entries...), nil
+}
+
+// Stat returns the FileInfo of the named file or directory without
+// decrypting its contents
+func (fsys *FS) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+
+	if _, ok := fsys.dirs[name]; ok {
+		return fileInfo{name: name}, nil
+	}
+
+	id, ok := fsys.files[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+
+	return fileInfo{file: &fsys.d.almanac.Files[id], name: name}, nil
+}
+
+// fileInfo describes a file in the almanac, or a directory if file is nil
+type fileInfo struct {
+	file *File
+	// name is the cleaned path within the file system
+	name string
+}
+
+func (i fileInfo) Name() string {
+	return path.Base(i.name)
+}
+
+func (i fileInfo) Size() int64 {
+	if i.file != nil {
+		return int64(i.file.Size)
+	}
+
+	return 0
+}
+
+func (i fileInfo) Mode() fs.FileMode {
+	if i.file != nil {
+		return fsFilePermissions
+	}
+
+	return fs.ModeDir | fsDirPermissions
+}
+
+// ModTime returns the modified date, stored as seconds since the unix epoch
+func (i fileInfo) ModTime() time.Time {
+	if i.file != nil {
+		return time.Unix(int64(i.file.Modified), 0)
+	}
+
+	return time.Time{}
+}
+
+func (i fileInfo) IsDir() bool {
+	return i.file == nil
+}
+
+func (i fileInfo) Sys() interface{} {
+	return nil
+}
+
+// file is an open file from the archive, the contents have already been
+// authenticated
+type file struct {
+	*bytes.Reader
+	info fileInfo
+}
+
+func (f *file) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *file) Close() error {
+	return nil
+}
+
+// dir is an open directory
+type dir struct {
+	info    fileInfo
+	entries []fs.DirEntry
+	// offset is the number of entries already returned by ReadDir
+	offset int
+}
+
+func (d *dir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *dir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: ErrIsDir}
+}
+
+func (d *dir) Close() error {
+	return nil
+}
+
+func (d *dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	remaining := d.entries[d.offset:]
+	if n <= 0 {
+		d.offset = len(d.entries)
+		return append([]fs.DirEntry(nil), remaining...), nil
+	}
+
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+
+	if n > len(remaining) {
+		n = len(remaining)
+	}
+
+	d.offset += n
+	return append([]fs.DirEntry(nil), remaining[:n]...), nil
+}
@@ -0,0 +1,141 @@
+package zar
+
+import (
+	"bytes"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+)
+
+func TestFS(t *testing.T) {
+	archive, err := encodeArchive(WithBlockSize(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := NewDecoder(bytes.NewReader(archive), testArchiveKey, int64(len(archive)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fsys, err := d.FS()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fstest.TestFS(fsys, "test.txt", "test2.txt", "some/file.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	contents, err := fs.ReadFile(fsys, "some/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(contents) != "mid 18th Century" {
+		t.Fatalf("expected %q got %q", "mid 18th Century", contents)
+	}
+
+	info, err := fs.Stat(fsys, "some")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !info.IsDir() {
+		t.Fatal("expected some to be a directory")
+	}
+}
+
+func TestFSDirectoryClash(t *testing.T) {
+	for _, names := range [][]string{{"a", "a/b"}, {"a/b", "a"}} {
+		output := bytes.NewBuffer(nil)
+		archive, err := New(output, testArchiveKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, name := range names {
+			if _, err := archive.Add(name, 0, bytes.NewBufferString(name)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := archive.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		d, err := NewDecoder(bytes.NewReader(output.Bytes()), testArchiveKey, int64(output.Len()))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		fsys, err := d.FS()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := fstest.TestFS(fsys, "a/b"); err != nil {
+			t.Fatal(err)
+		}
+
+		entries, err := fs.ReadDir(fsys, ".")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(entries) != 1 || !entries[0].IsDir() {
+			t.Fatalf("expected a single directory got %v", entries)
+		}
+	}
+}
+
+func TestFSDuplicateName(t *testing.T) {
+	output := bytes.NewBuffer(nil)
+	archive, err := New(output, testArchiveKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, contents := range []string{"a", "later"} {
+		if _, err := archive.Add("a.txt", 0, bytes.NewBufferString(contents)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := NewDecoder(bytes.NewReader(output.Bytes()), testArchiveKey, int64(output.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fsys, err := d.FS()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fstest.TestFS(fsys, "a.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the last file with the name is used, as with Decoder.Open
+	contents, err := fs.ReadFile(fsys, "a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(contents) != "later" {
+		t.Fatalf("expected %q got %q", "later", contents)
+	}
+
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 1 {
+		t.Fatalf("expected a single entry got %v", entries)
+	}
+}
@@ -1,13 +1,10 @@
 module github.com/go-compile/zar
 
-go 1.18
+go 1.24
 
 require (
 	github.com/andybalholm/brotli v1.0.4
 	golang.org/x/crypto v0.0.0-20220622213112-05595931fe9d
 )
 
-require (
-	github.com/dchest/siphash v1.2.3 // indirect
-	golang.org/x/sys v0.0.0-20210615035016-665e8c7367d1 // indirect
-)
+require golang.org/x/sys v0.0.0-20210615035016-665e8c7367d1 // indirect
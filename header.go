@@ -0,0 +1,184 @@
+package zar
+
+import (
+	"crypto/aes"
+	"encoding/binary"
+	"errors"
+)
+
+// HeaderSize is the length of the encoded Header
+const HeaderSize = 16
+
+// MagicNumber identifies a file as a zar archive
+var MagicNumber = [3]byte{'Z', 'A', 'R'}
+
+// Compatibility policy
+//
+// The format version is only incremented when the layout of the archive
+// changes in a way older decoders cannot parse. A decoder opens every version
+// from MinVersion up to and including FormatVersion, and refuses newer ones
+// with ErrUnsupportedVersion.
+//
+// Additions which do not change the layout are signalled with feature flags
+// instead of a new version. A required feature changes how the archive must
+// be decoded, so a decoder refuses archives with required features it does
+// not know with ErrUnknownRequiredFeature. An optional feature can be ignored
+// without affecting the decoded output, so unknown optional features are
+// permitted.
+const (
+	// FormatVersion is the archive format version written by the encoder
+	FormatVersion uint8 = 1
+	// MinVersion is the oldest archive format version the decoder can open
+	MinVersion uint8 = 1
+)
+
+// Feature is a bitset of archive format features
+type Feature uint32
+
+const (
+	// knownRequiredFeatures is the set of required features understood
+	// by the decoder
+	knownRequiredFeatures = FeatureStreaming | FeatureSignature | FeatureKeyfile | FeatureFileKeys
+)
+
+// Has reports whether all the features in x are set
+func (f Feature) Has(x Feature) bool {
+	return f&x == x
+}
+
+const (
+	// ModeKeySlots wraps the archive's data key into key slots
+	ModeKeySlots uint8 = 2
+)
+
+const (
+	// CipherAES256CTR encrypts the body with AES_256_CTR
+	CipherAES256CTR uint8 = 1
+	// CipherAES256GCM seals the body in chunks with AES-256-GCM
+	CipherAES256GCM uint8 = 2
+	// CipherXChaCha20Poly1305 seals the body in chunks with
+	// XChaCha20-Poly1305, which is faster than AES on CPUs without AES
+	// instructions
+	CipherXChaCha20Poly1305 uint8 = 3
+)
+
+const (
+	// MacHMACSHA512 authenticates the body with HMAC-SHA512 and each block
+	// with HMAC-SHA256
+	MacHMACSHA512 uint8 = 1
+)
+
+const (
+	// CompressionBrotli compresses blocks and the almanac with Brotli
+	CompressionBrotli uint8 = 1
+)
+
+var (
+	// ErrMagicNumber is returned when the input does not start with the zar
+	// magic number
+	ErrMagicNumber = errors.New("not a zar archive")
+	// ErrUnsupportedVersion is returned when the archive was written with a
+	// format version the decoder does not support
+	ErrUnsupportedVersion = errors.New("unsupported archive format version")
+	// ErrUnknownRequiredFeature is returned when the archive requires a
+	// feature the decoder does not implement
+	ErrUnknownRequiredFeature = errors.New("archive requires an unknown feature")
+	// ErrUnsupportedMode is returned when the header's mode is unknown
+	ErrUnsupportedMode = errors.New("unsupported archive mode")
+	// ErrUnsupportedCipher is returned when the header's cipher suite is unknown
+	ErrUnsupportedCipher = errors.New("unsupported cipher suite")
+	// ErrUnsupportedMac is returned when the header's MAC is unknown
+	ErrUnsupportedMac = errors.New("unsupported message authentication code")
+	// ErrUnsupportedCompression is returned when the header's compression
+	// algorithm is unknown
+	ErrUnsupportedCompression = errors.New("unsupported compression algorithm")
+)
+
+// defaultHeader returns the header for the algorithms used by the encoder
+func defaultHeader() Header {
+	return Header{
+		MagicNumber: MagicNumber,
+		Version:     FormatVersion,
+		Mode:        ModeKeySlots,
+		CipherSuite: CipherAES256CTR,
+		Mac:         MacHMACSHA512,
+		Compression: CompressionBrotli,
+	}
+}
+
+// CipherBlockSize returns the size of the unit the cipher suite encrypts the
+// body in, the AES block for CTR and the chunk for chunked suites
+func (h *Header) CipherBlockSize() uint64 {
+	if chunkedSuite(h.CipherSuite) {
+		return ChunkSize
+	}
+
+	return aes.BlockSize
+}
+
+// Marshal encodes the header into its binary form
+func (h *Header) Marshal() []byte {
+	buf := make([]byte, HeaderSize)
+
+	copy(buf, h.MagicNumber[:])
+	buf[3] = h.Version
+	binary.BigEndian.PutUint32(buf[4:], uint32(h.RequiredFeatures))
+	binary.BigEndian.PutUint32(buf[8:], uint32(h.OptionalFeatures))
+	buf[12] = h.Mode
+	buf[13] = h.CipherSuite
+	buf[14] = h.Mac
+	buf[15] = h.Compression
+
+	return buf
+}
+
+// unmarshalHeader decodes and validates a header
+func unmarshalHeader(buf []byte) (*Header, error) {
+	if len(buf) < HeaderSize {
+		return nil, ErrMagicNumber
+	}
+
+	h := &Header{
+		Version:          buf[3],
+		RequiredFeatures: Feature(binary.BigEndian.Uint32(buf[4:])),
+		OptionalFeatures: Feature(binary.BigEndian.Uint32(buf[8:])),
+		Mode:             buf[12],
+		CipherSuite:      buf[13],
+		Mac:              buf[14],
+		Compression:      buf[15],
+	}
+
+	copy(h.MagicNumber[:], buf[:3])
+
+	if h.MagicNumber != MagicNumber {
+		return nil, ErrMagicNumber
+	}
+
+	// the version must be checked before anything else is interpreted as
+	// newer versions may change the meaning of the remaining fields
+	if h.Version < MinVersion || h.Version > FormatVersion {
+		return nil, ErrUnsupportedVersion
+	}
+
+	if !knownRequiredFeatures.Has(h.RequiredFeatures) {
+		return nil, ErrUnknownRequiredFeature
+	}
+
+	if h.Mode != ModeKeySlots {
+		return nil, ErrUnsupportedMode
+	}
+
+	if h.CipherSuite != CipherAES256CTR && !chunkedSuite(h.CipherSuite) {
+		return nil, ErrUnsupportedCipher
+	}
+
+	if h.Mac != MacHMACSHA512 {
+		return nil, ErrUnsupportedMac
+	}
+
+	if h.Compression != CompressionBrotli {
+		return nil, ErrUnsupportedCompression
+	}
+
+	return h, nil
+}
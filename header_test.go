@@ -0,0 +1,114 @@
+package zar
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestHeaderMarshal(t *testing.T) {
+	h := defaultHeader()
+
+	decoded, err := unmarshalHeader(h.Marshal())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *decoded != h {
+		t.Fatalf("expected %+v got %+v", h, *decoded)
+	}
+}
+
+func TestHeaderInvalid(t *testing.T) {
+	archive, err := encodeArchive()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		offset int
+		err    error
+	}{
+		{0, ErrMagicNumber},
+		{3, ErrUnsupportedVersion},
+		{4, ErrUnknownRequiredFeature},
+		{12, ErrUnsupportedMode},
+		{13, ErrUnsupportedCipher},
+		{14, ErrUnsupportedMac},
+		{15, ErrUnsupportedCompression},
+		{24, ErrKDFParams},
+	}
+
+	for _, c := range cases {
+		buf := append([]byte(nil), archive...)
+		buf[c.offset] = 0xFF
+
+		_, err := NewDecoder(bytes.NewReader(buf), testArchiveKey, int64(len(buf)))
+		if err != c.err {
+			t.Fatalf("byte %d: expected %q got %v", c.offset, c.err, err)
+		}
+	}
+
+	_, err = NewDecoder(bytes.NewReader([]byte("ZA")), testArchiveKey, 2)
+	if err != ErrMagicNumber {
+		t.Fatalf("expected %q got %v", ErrMagicNumber, err)
+	}
+}
+
+func TestKDFParams(t *testing.T) {
+	params := KDFParams{Time: 1, Memory: 1 << 10, Threads: 2}
+
+	archive, err := encodeArchive(WithKDFParams(params))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := NewDecoder(bytes.NewReader(archive), testArchiveKey, int64(len(archive)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if slots := d.KeySlots(); slots[0].KDF != params {
+		t.Fatalf("expected %+v got %+v", params, slots[0].KDF)
+	}
+
+	if err := d.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := encodeArchive(WithKDFParams(KDFParams{Time: 1, Memory: 1 << 10})); err != ErrKDFParams {
+		t.Fatalf("expected %q got %v", ErrKDFParams, err)
+	}
+}
+
+func TestHeaderBound(t *testing.T) {
+	archive, err := encodeArchive()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// unknown optional features are permitted but the key slots are sealed
+	// with the header as associated data
+	archive[8] ^= 0x80
+
+	if _, err := NewDecoder(bytes.NewReader(archive), testArchiveKey, int64(len(archive))); err != ErrNoKeySlot {
+		t.Fatalf("expected %q got %v", ErrNoKeySlot, err)
+	}
+}
+
+func TestHeaderOptionalFeatures(t *testing.T) {
+	h := defaultHeader()
+	h.OptionalFeatures = 1 << 31
+
+	decoded, err := unmarshalHeader(h.Marshal())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded.Version != FormatVersion {
+		t.Fatalf("expected version %d got %d", FormatVersion, decoded.Version)
+	}
+
+	if !decoded.OptionalFeatures.Has(1 << 31) {
+		t.Fatal("expected unknown optional feature to be preserved")
+	}
+}
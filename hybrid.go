@@ -0,0 +1,181 @@
+package zar
+
+import (
+	"crypto/mlkem"
+	"crypto/sha256"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Hybrid recipients
+//
+// A hybrid recipient slot seals the data key for both an X25519 and an
+// ML-KEM-768 public key, so the archive stays confidential if either
+// primitive holds. The key encryption key is derived with HKDF-SHA256 from
+// both shared secrets, bound to the X25519 public keys and the ML-KEM
+// ciphertext:
+//
+//	Hybrid Slot
+//	    - Ephemeral X25519 Public Key (32 bytes)
+//	    - ML-KEM-768 Ciphertext (1088 bytes)
+//	    - Sealed Data Key (48 bytes)
+//
+//	Key Encryption Key = HKDF-SHA256(
+//	    ML-KEM Shared Secret || X25519 Shared Secret,
+//	    "zar hybrid" || Ephemeral Public Key || X25519 Public Key || Ciphertext)
+//
+// A hybrid public key is the X25519 public key followed by the ML-KEM-768
+// encapsulation key, and the private key is the X25519 private key followed
+// by the ML-KEM-768 seed. The archive is opened by passing the private key
+// to NewDecoder in place of a password.
+
+const (
+	// SlotHybrid is a key slot unlocked by a hybrid X25519 and ML-KEM-768
+	// private key
+	SlotHybrid uint8 = 4
+)
+
+const (
+	// HybridPublicKeySize is the length of a hybrid recipient public key
+	HybridPublicKeySize = curve25519.PointSize + mlkem.EncapsulationKeySize768
+	// HybridPrivateKeySize is the length of a hybrid recipient private key
+	HybridPrivateKeySize = curve25519.ScalarSize + mlkem.SeedSize
+
+	// hybridSlotSize is the length of a hybrid slot's payload
+	hybridSlotSize = curve25519.PointSize + mlkem.CiphertextSize768 + sealedKeySize
+
+	labelHybrid = "zar hybrid"
+)
+
+// ErrHybridKey is returned when a hybrid recipient public key is malformed
+var ErrHybridKey = errors.New("invalid hybrid recipient public key")
+
+// GenerateHybridRecipientKey generates a hybrid X25519 and ML-KEM-768 key
+// pair. The public key is given to WithHybridRecipient and the private key
+// opens the archive.
+func GenerateHybridRecipientKey() (publicKey, privateKey []byte, err error) {
+	x25519Public, x25519Private, err := GenerateRecipientKey()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dk, err := mlkem.GenerateKey768()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	publicKey = append(x25519Public, dk.EncapsulationKey().Bytes()...)
+	privateKey = append(x25519Private, dk.Bytes()...)
+
+	return publicKey, privateKey, nil
+}
+
+// WithHybridRecipient seals the archive's data key for a hybrid X25519 and
+// ML-KEM-768 public key, the archive can then be opened by passing the
+// matching private key to NewDecoder
+func WithHybridRecipient(publicKey []byte) Option {
+	return func(e *Encoder) error {
+		if len(publicKey) != HybridPublicKeySize {
+			return ErrHybridKey
+		}
+
+		if _, err := mlkem.NewEncapsulationKey768(publicKey[curve25519.PointSize:]); err != nil {
+			return ErrHybridKey
+		}
+
+		e.hybridRecipients = append(e.hybridRecipients, publicKey)
+		return nil
+	}
+}
+
+// newHybridSlot seals the data key into a new slot for the hybrid public key
+func newHybridSlot(publicKey, dataKey, header []byte) (KeySlot, error) {
+	if len(publicKey) != HybridPublicKeySize {
+		return KeySlot{}, ErrHybridKey
+	}
+
+	x25519Public := publicKey[:curve25519.PointSize]
+	ek, err := mlkem.NewEncapsulationKey768(publicKey[curve25519.PointSize:])
+	if err != nil {
+		return KeySlot{}, ErrHybridKey
+	}
+
+	ephemeralPublic, ephemeral, err := GenerateRecipientKey()
+	if err != nil {
+		return KeySlot{}, err
+	}
+
+	x25519Shared, err := curve25519.X25519(ephemeral, x25519Public)
+	if err != nil {
+		return KeySlot{}, ErrHybridKey
+	}
+
+	mlkemShared, ciphertext := ek.Encapsulate()
+
+	kek, err := hybridKEK(mlkemShared, x25519Shared, ephemeralPublic, x25519Public, ciphertext)
+	if err != nil {
+		return KeySlot{}, err
+	}
+
+	aead, err := slotCipher(kek)
+	if err != nil {
+		return KeySlot{}, err
+	}
+
+	return KeySlot{
+		Kind:          SlotHybrid,
+		EphemeralKey:  ephemeralPublic,
+		KEMCiphertext: ciphertext,
+		SealedKey:     aead.Seal(nil, make([]byte, aead.NonceSize()), dataKey, header),
+	}, nil
+}
+
+// hybridUnwrap derives the key encryption key of a slot from the hybrid
+// private key
+func hybridUnwrap(privateKey, ephemeralPublic, ciphertext []byte) ([]byte, error) {
+	if len(privateKey) != HybridPrivateKeySize {
+		return nil, ErrHybridKey
+	}
+
+	x25519Private := privateKey[:curve25519.ScalarSize]
+	x25519Public, err := curve25519.X25519(x25519Private, curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+
+	x25519Shared, err := curve25519.X25519(x25519Private, ephemeralPublic)
+	if err != nil {
+		return nil, err
+	}
+
+	dk, err := mlkem.NewDecapsulationKey768(privateKey[curve25519.ScalarSize:])
+	if err != nil {
+		return nil, err
+	}
+
+	mlkemShared, err := dk.Decapsulate(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+
+	return hybridKEK(mlkemShared, x25519Shared, ephemeralPublic, x25519Public, ciphertext)
+}
+
+// hybridKEK derives the key encryption key from both shared secrets
+func hybridKEK(mlkemShared, x25519Shared, ephemeralPublic, x25519Public, ciphertext []byte) ([]byte, error) {
+	secret := append(append([]byte(nil), mlkemShared...), x25519Shared...)
+
+	info := append([]byte(labelHybrid), ephemeralPublic...)
+	info = append(info, x25519Public...)
+	info = append(info, ciphertext...)
+
+	kek := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, secret, nil, info), kek); err != nil {
+		return nil, err
+	}
+
+	return kek, nil
+}
@@ -0,0 +1,68 @@
+package zar
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestHybridRecipient(t *testing.T) {
+	publicKey, privateKey, err := GenerateHybridRecipientKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, other, err := GenerateHybridRecipientKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output := bytes.NewBuffer(nil)
+	archive, err := New(output, nil, WithHybridRecipient(publicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := archive.Add("test.txt", 0, bytes.NewReader([]byte("contents"))); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := output.Bytes()
+
+	d, err := NewDecoder(bytes.NewReader(buf), privateKey, int64(len(buf)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if slots := d.KeySlots(); len(slots) != 1 || slots[0].Kind != SlotHybrid {
+		t.Fatalf("expected a single hybrid slot got %+v", slots)
+	}
+
+	if err := d.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	// neither half of the private key opens the archive alone, nor does a
+	// key with the other half swapped
+	swapped := append(append([]byte(nil), privateKey[:32]...), other[32:]...)
+	for _, key := range [][]byte{other, privateKey[:32], privateKey[32:], swapped} {
+		if _, err := NewDecoder(bytes.NewReader(buf), key, int64(len(buf))); err != ErrNoKeySlot {
+			t.Fatalf("expected %q got %v", ErrNoKeySlot, err)
+		}
+	}
+
+	// modify the ML-KEM ciphertext
+	buf[HeaderSize+1+keySlotHeaderSize+40] ^= 1
+	if _, err := NewDecoder(bytes.NewReader(buf), privateKey, int64(len(buf))); err != ErrNoKeySlot {
+		t.Fatalf("expected %q got %v", ErrNoKeySlot, err)
+	}
+
+	for _, key := range [][]byte{publicKey[:32], append(publicKey[:32:32], bytes.Repeat([]byte{0xff}, HybridPublicKeySize-32)...)} {
+		if _, err := New(bytes.NewBuffer(nil), nil, WithHybridRecipient(key)); err != ErrHybridKey {
+			t.Fatalf("expected %q got %v", ErrHybridKey, err)
+		}
+	}
+}
@@ -11,9 +11,12 @@ import (
 const compressionBlockSize = 300
 
 func TestIndexStart(t *testing.T) {
+	header := zar.Header{CipherSuite: zar.CipherAES256CTR}
+	blockSize := header.CipherBlockSize()
+
 	_, files := generateFile(10)
 	for i, f := range files {
-		fmt.Printf("%d: Block: %d Len %d Start: %d CryptoBlock: %d Relative Crypto Block Offset: %d\n", i, f.Offset, f.Size, f.Start(files, i), f.CipherBlock(), f.CipherBlockOffset())
+		fmt.Printf("%d: Block: %d Len %d Start: %d CryptoBlock: %d Relative Crypto Block Offset: %d\n", i, f.Offset, f.Size, f.Start(files, i), f.CipherBlock(blockSize), f.CipherBlockOffset(blockSize))
 	}
 }
 
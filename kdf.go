@@ -0,0 +1,149 @@
+package zar
+
+import (
+	"encoding/binary"
+	"errors"
+	"runtime"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// KDFParams are the Argon2id parameters used to derive a key slot's key from
+// its password. They are stored in the key slot so the decoder can reproduce
+// the derivation, a slot with altered parameters derives a different key and
+// so fails to unlock.
+type KDFParams struct {
+	// Time is the number of passes over the memory
+	Time uint32
+	// Memory is the amount of memory used in KiB
+	Memory uint32
+	// Threads is the degree of parallelism
+	Threads uint8
+}
+
+// kdfParamsSize is the length of the encoded KDFParams
+const kdfParamsSize = 9
+
+// maxKDFMemory is the most memory in KiB the decoder will use to derive the
+// keys, which stops a crafted header from exhausting the host's memory
+const maxKDFMemory = 4 << 20
+
+// maxKDFTime is the most passes the decoder will run to derive the keys,
+// which stops a crafted header from stalling the decoder
+const maxKDFTime = 64
+
+var (
+	// KDFInteractive is suitable for archives opened interactively, using
+	// 64 MiB of memory
+	KDFInteractive = KDFParams{Time: 2, Memory: 64 << 10, Threads: 4}
+	// KDFModerate is slower than KDFInteractive, using 256 MiB of memory
+	KDFModerate = KDFParams{Time: 3, Memory: 256 << 10, Threads: 4}
+	// KDFSensitive is for highly sensitive archives where the derivation
+	// may take several seconds, using 1 GiB of memory
+	KDFSensitive = KDFParams{Time: 4, Memory: 1 << 20, Threads: 4}
+)
+
+var (
+	// ErrKDFParams is returned when the Argon2id parameters are out of range
+	ErrKDFParams = errors.New("invalid key derivation parameters")
+	// ErrKDFTarget is returned by CalibrateKDF when the derivation cannot
+	// complete within the target duration even with the least memory
+	ErrKDFTarget = errors.New("key derivation cannot meet target duration")
+)
+
+// validate checks the parameters are accepted by Argon2id and within the
+// decoder's memory and time limits
+func (p KDFParams) validate() error {
+	if p.Time < 1 || p.Time > maxKDFTime || p.Threads < 1 {
+		return ErrKDFParams
+	}
+
+	// Argon2 requires at least 8 KiB per thread
+	if p.Memory < 8*uint32(p.Threads) || p.Memory > maxKDFMemory {
+		return ErrKDFParams
+	}
+
+	return nil
+}
+
+func (p KDFParams) marshal(buf []byte) {
+	binary.BigEndian.PutUint32(buf, p.Time)
+	binary.BigEndian.PutUint32(buf[4:], p.Memory)
+	buf[8] = p.Threads
+}
+
+func unmarshalKDFParams(buf []byte) KDFParams {
+	return KDFParams{
+		Time:    binary.BigEndian.Uint32(buf),
+		Memory:  binary.BigEndian.Uint32(buf[4:]),
+		Threads: buf[8],
+	}
+}
+
+// MeasureKDF returns how long deriving the keys with the parameters takes on
+// the current machine
+func MeasureKDF(params KDFParams) (time.Duration, error) {
+	if err := params.validate(); err != nil {
+		return 0, err
+	}
+
+	salt := make([]byte, saltSize)
+
+	start := time.Now()
+	argon2.IDKey([]byte("zar calibration"), salt, params.Time, params.Memory, params.Threads, 32)
+
+	return time.Since(start), nil
+}
+
+// CalibrateKDF picks Argon2id parameters which derive the keys within the
+// target duration on the current machine. Memory is preferred over time, so
+// the largest memory up to maxMemory KiB is chosen first and any remaining
+// time is spent on additional passes.
+//
+// The result can be stored in an archive with WithKDFParams.
+func CalibrateKDF(target time.Duration, maxMemory uint32) (KDFParams, error) {
+	if maxMemory > maxKDFMemory {
+		maxMemory = maxKDFMemory
+	}
+
+	params := KDFParams{Time: 1, Memory: maxMemory, Threads: kdfThreads()}
+	if err := params.validate(); err != nil {
+		return KDFParams{}, err
+	}
+
+	minMemory := 8 * uint32(params.Threads)
+
+	for {
+		elapsed, err := MeasureKDF(params)
+		if err != nil {
+			return KDFParams{}, err
+		}
+
+		if elapsed <= target {
+			// the cost of each pass is roughly constant
+			if passes := target / (elapsed + 1); passes > maxKDFTime {
+				params.Time = maxKDFTime
+			} else if passes > 1 {
+				params.Time = uint32(passes)
+			}
+
+			return params, nil
+		}
+
+		if params.Memory/2 < minMemory {
+			return KDFParams{}, ErrKDFTarget
+		}
+
+		params.Memory /= 2
+	}
+}
+
+// kdfThreads returns the parallelism used by CalibrateKDF
+func kdfThreads() uint8 {
+	if n := runtime.NumCPU(); n < 4 {
+		return uint8(n)
+	}
+
+	return 4
+}
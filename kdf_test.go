@@ -0,0 +1,41 @@
+package zar
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCalibrateKDF(t *testing.T) {
+	params, err := CalibrateKDF(100*time.Millisecond, 4<<10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := params.validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if params.Memory > 4<<10 {
+		t.Fatalf("expected memory at most %d KiB got %d", 4<<10, params.Memory)
+	}
+
+	if _, err := CalibrateKDF(time.Nanosecond, 4<<10); err != ErrKDFTarget {
+		t.Fatalf("expected %q got %v", ErrKDFTarget, err)
+	}
+
+	if _, err := MeasureKDF(KDFParams{}); err != ErrKDFParams {
+		t.Fatalf("expected %q got %v", ErrKDFParams, err)
+	}
+
+	if _, err := MeasureKDF(KDFParams{Time: maxKDFTime + 1, Memory: 1 << 10, Threads: 1}); err != ErrKDFParams {
+		t.Fatalf("expected %q got %v", ErrKDFParams, err)
+	}
+}
+
+func BenchmarkKDFInteractive(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		if _, err := MeasureKDF(KDFInteractive); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package zar
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"errors"
+	"io"
+	"sort"
+)
+
+// Keyfiles
+//
+// Password slots can additionally require one or more keyfiles, so that a
+// stolen password alone does not open the archive. Each keyfile is hashed
+// with SHA-512 and the hashes are combined in ascending byte order, so the
+// keyfiles can be given in any order. The combined digest is appended to the
+// password before it is passed to Argon2id:
+//
+//	Keyfiles Digest
+//	    - SHA-512 of
+//	        - Label "zar keyfiles"
+//	        - []SHA-512 of each Keyfile, sorted (64 bytes each)
+//
+//	Argon2id Input
+//	    - Password
+//	    - Keyfiles Digest (64 bytes)
+//
+// Archives written with WithKeyfiles set FeatureKeyfile, and every password
+// slot requires the keyfiles. Recipient and share slots are unaffected.
+
+const (
+	// FeatureKeyfile marks an archive's password slots as requiring
+	// keyfiles in addition to the password
+	FeatureKeyfile Feature = 1 << 2
+)
+
+const labelKeyfiles = "zar keyfiles"
+
+var (
+	// ErrKeyfile is returned when no keyfiles are given
+	ErrKeyfile = errors.New("no keyfiles given")
+	// ErrKeyfileRequired is returned when an archive's password slots
+	// require keyfiles and none were given to the decoder
+	ErrKeyfileRequired = errors.New("keyfile required")
+)
+
+// WithKeyfiles requires the keyfiles, as well as the password, to unlock
+// every password slot of the archive. Pass an empty, non-nil password to New
+// to rely on the keyfiles alone.
+func WithKeyfiles(keyfiles ...io.Reader) Option {
+	return func(e *Encoder) error {
+		digest, err := hashKeyfiles(keyfiles)
+		if err != nil {
+			return err
+		}
+
+		e.header.RequiredFeatures |= FeatureKeyfile
+		e.keyfiles = digest
+		return nil
+	}
+}
+
+// WithDecoderKeyfiles gives the keyfiles needed to unlock the password slots
+// of an archive written with WithKeyfiles. They are ignored if the archive
+// does not require keyfiles.
+func WithDecoderKeyfiles(keyfiles ...io.Reader) DecoderOption {
+	return func(d *Decoder) error {
+		digest, err := hashKeyfiles(keyfiles)
+		if err != nil {
+			return err
+		}
+
+		d.keyfiles = digest
+		return nil
+	}
+}
+
+// WithStreamKeyfiles gives the keyfiles needed to unlock the password slots
+// of an archive written with WithKeyfiles to a StreamReader
+func WithStreamKeyfiles(keyfiles ...io.Reader) StreamOption {
+	return func(s *StreamReader) error {
+		digest, err := hashKeyfiles(keyfiles)
+		if err != nil {
+			return err
+		}
+
+		s.keyfiles = digest
+		return nil
+	}
+}
+
+// hashKeyfiles returns the combined digest of the keyfiles
+func hashKeyfiles(keyfiles []io.Reader) ([]byte, error) {
+	if len(keyfiles) == 0 {
+		return nil, ErrKeyfile
+	}
+
+	hashes := make([][]byte, len(keyfiles))
+	for i, keyfile := range keyfiles {
+		h := sha512.New()
+		if _, err := io.Copy(h, keyfile); err != nil {
+			return nil, err
+		}
+
+		hashes[i] = h.Sum(nil)
+	}
+
+	sort.Slice(hashes, func(i, j int) bool {
+		return bytes.Compare(hashes[i], hashes[j]) < 0
+	})
+
+	h := sha512.New()
+	h.Write([]byte(labelKeyfiles))
+	for _, hash := range hashes {
+		h.Write(hash)
+	}
+
+	return h.Sum(nil), nil
+}
+
+// mixKeyfiles returns the Argon2id input of a password slot
+func mixKeyfiles(password, keyfiles []byte) []byte {
+	return append(append([]byte(nil), password...), keyfiles...)
+}
@@ -0,0 +1,104 @@
+package zar
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestKeyfiles(t *testing.T) {
+	keyfile1 := []byte("first keyfile")
+	keyfile2 := bytes.Repeat([]byte{7}, 1<<16)
+
+	output := bytes.NewBuffer(nil)
+	archive, err := New(output, testArchiveKey,
+		WithStreaming(),
+		WithKDFParams(testKDFParams),
+		WithKeyfiles(bytes.NewReader(keyfile1), bytes.NewReader(keyfile2)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := archive.Add("test.txt", 0, bytes.NewReader([]byte("contents"))); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := output.Bytes()
+
+	// the keyfiles may be given in any order
+	d, err := NewDecoder(bytes.NewReader(buf), testArchiveKey, int64(len(buf)),
+		WithDecoderKeyfiles(bytes.NewReader(keyfile2), bytes.NewReader(keyfile1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !d.Header().RequiredFeatures.Has(FeatureKeyfile) {
+		t.Fatal("expected keyfile feature to be set")
+	}
+
+	if err := d.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewDecoder(bytes.NewReader(buf), testArchiveKey, int64(len(buf))); err != ErrKeyfileRequired {
+		t.Fatalf("expected %q got %v", ErrKeyfileRequired, err)
+	}
+
+	if _, err := NewStreamReader(bytes.NewReader(buf), testArchiveKey); err != ErrKeyfileRequired {
+		t.Fatalf("expected %q got %v", ErrKeyfileRequired, err)
+	}
+
+	for _, keyfiles := range [][][]byte{
+		{keyfile1},
+		{keyfile1, keyfile2, keyfile2},
+		{keyfile1, append(keyfile2, 0)},
+	} {
+		var readers []io.Reader
+		for _, keyfile := range keyfiles {
+			readers = append(readers, bytes.NewReader(keyfile))
+		}
+
+		if _, err := NewDecoder(bytes.NewReader(buf), testArchiveKey, int64(len(buf)), WithDecoderKeyfiles(readers...)); err != ErrNoKeySlot {
+			t.Fatalf("expected %q got %v", ErrNoKeySlot, err)
+		}
+	}
+
+	s, err := NewStreamReader(bytes.NewReader(buf), testArchiveKey,
+		WithStreamKeyfiles(bytes.NewReader(keyfile1), bytes.NewReader(keyfile2)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for {
+		if _, err := s.Next(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the new password still requires the keyfiles
+	rekeyed := bytes.NewBuffer(nil)
+	if err := RekeyTo(rekeyed, bytes.NewReader(buf), int64(len(buf)), testArchiveKey, []byte("new password"),
+		WithDecoderKeyfiles(bytes.NewReader(keyfile1), bytes.NewReader(keyfile2))); err != nil {
+		t.Fatal(err)
+	}
+
+	buf = rekeyed.Bytes()
+	if _, err := NewDecoder(bytes.NewReader(buf), []byte("new password"), int64(len(buf))); err != ErrKeyfileRequired {
+		t.Fatalf("expected %q got %v", ErrKeyfileRequired, err)
+	}
+
+	if _, err := NewDecoder(bytes.NewReader(buf), []byte("new password"), int64(len(buf)),
+		WithDecoderKeyfiles(bytes.NewReader(keyfile1), bytes.NewReader(keyfile2))); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := New(bytes.NewBuffer(nil), testArchiveKey, WithKeyfiles()); err != ErrKeyfile {
+		t.Fatalf("expected %q got %v", ErrKeyfile, err)
+	}
+}
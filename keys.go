@@ -0,0 +1,77 @@
+package zar
+
+import (
+	"crypto/sha512"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// saltSize is the length of the KDF salt of a key slot
+	saltSize = 16
+	// nonceSize is the length of the cipher nonce stored after the key
+	// slots, it is large enough for the chunk nonce prefix of every cipher
+	// suite
+	nonceSize = 24
+)
+
+// HKDF info labels, each key is expanded with its own label followed by a zero
+// byte and the encoded header
+const (
+	labelMasterMac  = "zar master mac"
+	labelBlock      = "zar block tag"
+	labelCipher     = "zar encryption"
+	labelAlmanac    = "zar almanac tag"
+	labelAlmanacKey = "zar almanac key"
+)
+
+// archiveKeys holds the keys derived from the archive's data key
+type archiveKeys struct {
+	// master is the data key unlocked from a key slot, the remaining keys
+	// are derived from it
+	master []byte
+	// mac is used for the master mac
+	mac []byte
+	// block is used for the tags of each block
+	block []byte
+	// almanac is used for the tag of the almanac
+	almanac []byte
+	// cipher is used for encryption
+	cipher []byte
+	// almanacKey seals the almanac when files have their own keys
+	almanacKey []byte
+	// prk is the HKDF pseudorandom key the file keys are expanded from
+	prk []byte
+}
+
+// deriveKeys expands the data key into the individual archive keys. The
+// header is bound into every key, so an archive with an altered header
+// derives different keys.
+func deriveKeys(dataKey, nonce []byte, header *Header) (*archiveKeys, error) {
+	prk := hkdf.Extract(sha512.New, dataKey, nonce)
+	context := header.Marshal()
+
+	keys := &archiveKeys{master: dataKey, prk: prk}
+
+	for _, k := range []struct {
+		label string
+		key   *[]byte
+	}{
+		{labelMasterMac, &keys.mac},
+		{labelBlock, &keys.block},
+		{labelAlmanac, &keys.almanac},
+		{labelCipher, &keys.cipher},
+		{labelAlmanacKey, &keys.almanacKey},
+	} {
+		// derive additional keys from master
+		*k.key = make([]byte, 32)
+		info := append(append([]byte(k.label), 0), context...)
+
+		if _, err := io.ReadFull(hkdf.Expand(sha512.New, prk, info), *k.key); err != nil {
+			return nil, err
+		}
+	}
+
+	return keys, nil
+}
@@ -0,0 +1,362 @@
+package zar
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/mlkem"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/curve25519"
+)
+
+// Key slots
+//
+// The body is encrypted with keys derived from a random data key rather than
+// the password. The data key is wrapped into one or more key slots which
+// follow the header, any of which can be unlocked to open the archive:
+//
+//	Key Slots
+//	    - Count (1 byte)
+//	    - []KeySlot
+//	        - Kind (1 byte)
+//	        - Length (2 bytes)
+//	        - Payload
+//
+// A password slot derives a key encryption key from its password with
+// Argon2id and seals the data key with AES-256-GCM, using the header as
+// associated data:
+//
+//	Password Slot
+//	    - Argon2id Parameters (9 bytes)
+//	    - Salt (16 bytes)
+//	    - Sealed Data Key (48 bytes)
+//
+// Every slot has its own random salt and so a unique key encryption key,
+// which allows the data key to be sealed with a fixed nonce.
+
+const (
+	// SlotPassword is a key slot unlocked by a password
+	SlotPassword uint8 = 1
+	// SlotX25519 is a key slot unlocked by an X25519 private key
+	SlotX25519 uint8 = 2
+)
+
+const (
+	// MaxKeySlots is the most key slots an archive can hold
+	MaxKeySlots = 255
+	// MaxPasswordSlots is the most password slots an archive can hold, as
+	// the decoder runs a full key derivation for each one it tries
+	MaxPasswordSlots = 16
+
+	dataKeySize = 32
+	// sealedKeySize is the length of the data key sealed with AES-256-GCM
+	sealedKeySize = dataKeySize + 16
+	// keySlotHeaderSize is the length of a slot's kind and length
+	keySlotHeaderSize = 3
+	// passwordSlotSize is the length of a password slot's payload
+	passwordSlotSize = kdfParamsSize + saltSize + sealedKeySize
+)
+
+var (
+	// ErrKeySlot is returned when a key slot is malformed
+	ErrKeySlot = errors.New("invalid key slot")
+	// ErrKeySlots is returned when an archive has no key slots, more than
+	// MaxKeySlots or more than MaxPasswordSlots password slots
+	ErrKeySlots = errors.New("invalid number of key slots")
+	// ErrNoKeySlot is returned when the key does not unlock any of the
+	// archive's key slots
+	ErrNoKeySlot = errors.New("key does not unlock any key slot")
+)
+
+// KeySlot holds a copy of the archive's data key sealed for one password or
+// recipient
+type KeySlot struct {
+	// Kind identifies how the slot is unlocked
+	Kind uint8
+	// KDF are the Argon2id parameters of a password slot
+	KDF KDFParams
+	// Salt is the salt used to derive the key of a password slot
+	Salt []byte
+	// EphemeralKey is the ephemeral X25519 public key of a recipient or
+	// hybrid slot
+	EphemeralKey []byte
+	// KEMCiphertext is the ML-KEM-768 ciphertext of a hybrid slot
+	KEMCiphertext []byte
+	// Threshold is the number of key shares needed to unlock a share slot
+	Threshold uint8
+	// SealedKey is the data key sealed with the slot's key
+	SealedKey []byte
+
+	// offset is the position of the slot's payload from the start of the
+	// key slots section
+	offset int64
+}
+
+// slotPassword is a password the encoder seals the data key for
+type slotPassword struct {
+	password []byte
+	kdf      KDFParams
+}
+
+// newPasswordSlot seals the data key into a new password slot
+func newPasswordSlot(password, dataKey []byte, params KDFParams, header []byte) (KeySlot, error) {
+	if err := params.validate(); err != nil {
+		return KeySlot{}, err
+	}
+
+	salt := make([]byte, saltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return KeySlot{}, err
+	}
+
+	aead, err := slotCipher(argon2.IDKey(password, salt, params.Time, params.Memory, params.Threads, 32))
+	if err != nil {
+		return KeySlot{}, err
+	}
+
+	return KeySlot{
+		Kind:      SlotPassword,
+		KDF:       params,
+		Salt:      salt,
+		SealedKey: aead.Seal(nil, make([]byte, aead.NonceSize()), dataKey, header),
+	}, nil
+}
+
+// unlock returns the data key if the key opens the slot
+func (s *KeySlot) unlock(key, header []byte) ([]byte, bool) {
+	var kek []byte
+
+	switch s.Kind {
+	case SlotPassword:
+		kek = argon2.IDKey(key, s.Salt, s.KDF.Time, s.KDF.Memory, s.KDF.Threads, 32)
+	case SlotX25519:
+		var err error
+		if kek, err = x25519Unwrap(key, s.EphemeralKey); err != nil {
+			return nil, false
+		}
+	case SlotShares:
+		kek = shareKEK(key)
+	case SlotHybrid:
+		var err error
+		if kek, err = hybridUnwrap(key, s.EphemeralKey, s.KEMCiphertext); err != nil {
+			return nil, false
+		}
+	default:
+		return nil, false
+	}
+
+	aead, err := slotCipher(kek)
+	if err != nil {
+		return nil, false
+	}
+
+	dataKey, err := aead.Open(nil, make([]byte, aead.NonceSize()), s.SealedKey, header)
+	if err != nil {
+		return nil, false
+	}
+
+	return dataKey, true
+}
+
+// slotCipher returns the AEAD used to seal the data key
+func slotCipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// unlockKeySlots tries each slot in turn and returns the data key and index
+// of the first slot the key unlocks. Recipient slots are tried before
+// password slots as they are cheap to attempt. Password slots are unlocked
+// with the keyfiles digest mixed into the key when the archive requires
+// keyfiles, ErrKeyfileRequired is returned if none were given.
+func unlockKeySlots(slots []KeySlot, key, keyfiles []byte, header *Header) ([]byte, int, error) {
+	buf := header.Marshal()
+
+	for _, password := range []bool{false, true} {
+		slotKey := key
+		if password && header.RequiredFeatures.Has(FeatureKeyfile) {
+			if keyfiles == nil {
+				break
+			}
+
+			slotKey = mixKeyfiles(key, keyfiles)
+		}
+
+		for i := range slots {
+			if (slots[i].Kind == SlotPassword) != password {
+				continue
+			}
+
+			if dataKey, ok := slots[i].unlock(slotKey, buf); ok {
+				return dataKey, i, nil
+			}
+		}
+	}
+
+	if header.RequiredFeatures.Has(FeatureKeyfile) && keyfiles == nil {
+		for _, s := range slots {
+			if s.Kind == SlotPassword {
+				return nil, 0, ErrKeyfileRequired
+			}
+		}
+	}
+
+	return nil, 0, ErrNoKeySlot
+}
+
+// payload encodes the slot's payload
+func (s *KeySlot) payload() []byte {
+	switch s.Kind {
+	case SlotX25519:
+		return append(append([]byte(nil), s.EphemeralKey...), s.SealedKey...)
+	case SlotShares:
+		return append([]byte{s.Threshold}, s.SealedKey...)
+	case SlotHybrid:
+		payload := append(append([]byte(nil), s.EphemeralKey...), s.KEMCiphertext...)
+		return append(payload, s.SealedKey...)
+	}
+
+	payload := make([]byte, passwordSlotSize)
+	s.KDF.marshal(payload)
+	copy(payload[kdfParamsSize:], s.Salt)
+	copy(payload[kdfParamsSize+saltSize:], s.SealedKey)
+
+	return payload
+}
+
+// marshalKeySlots encodes the key slots section
+func marshalKeySlots(slots []KeySlot) []byte {
+	buf := bytes.NewBuffer([]byte{uint8(len(slots))})
+
+	for _, s := range slots {
+		payload := s.payload()
+
+		header := make([]byte, keySlotHeaderSize)
+		header[0] = s.Kind
+		binary.BigEndian.PutUint16(header[1:], uint16(len(payload)))
+
+		buf.Write(header)
+		buf.Write(payload)
+	}
+
+	return buf.Bytes()
+}
+
+// readKeySlots decodes the key slots section from r, returning the slots and
+// the length of the section. Slots of unknown kinds are skipped.
+func readKeySlots(r io.Reader) ([]KeySlot, int64, error) {
+	count := make([]byte, 1)
+	if _, err := io.ReadFull(r, count); err != nil {
+		return nil, 0, err
+	}
+
+	if count[0] == 0 {
+		return nil, 0, ErrKeySlots
+	}
+
+	n := int64(1)
+	var slots []KeySlot
+	var passwords int
+
+	header := make([]byte, keySlotHeaderSize)
+	for i := 0; i < int(count[0]); i++ {
+		if _, err := io.ReadFull(r, header); err != nil {
+			return nil, 0, err
+		}
+
+		payload := make([]byte, binary.BigEndian.Uint16(header[1:]))
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return nil, 0, err
+		}
+
+		offset := n + int64(len(header))
+		n = offset + int64(len(payload))
+
+		slot, ok, err := parseKeySlot(header[0], payload)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if ok {
+			slot.offset = offset
+			slots = append(slots, slot)
+		}
+
+		if slot.Kind == SlotPassword {
+			passwords++
+		}
+	}
+
+	if passwords > MaxPasswordSlots {
+		return nil, 0, ErrKeySlots
+	}
+
+	return slots, n, nil
+}
+
+// parseKeySlot decodes the payload of a slot, ok is false if the kind of
+// slot is unknown
+func parseKeySlot(kind uint8, payload []byte) (slot KeySlot, ok bool, err error) {
+	switch kind {
+	case SlotPassword:
+		if len(payload) != passwordSlotSize {
+			return KeySlot{}, false, ErrKeySlot
+		}
+
+		slot = KeySlot{
+			Kind:      SlotPassword,
+			KDF:       unmarshalKDFParams(payload),
+			Salt:      payload[kdfParamsSize : kdfParamsSize+saltSize],
+			SealedKey: payload[kdfParamsSize+saltSize:],
+		}
+
+		if err := slot.KDF.validate(); err != nil {
+			return KeySlot{}, false, err
+		}
+	case SlotX25519:
+		if len(payload) != x25519SlotSize {
+			return KeySlot{}, false, ErrKeySlot
+		}
+
+		slot = KeySlot{
+			Kind:         SlotX25519,
+			EphemeralKey: payload[:curve25519.PointSize],
+			SealedKey:    payload[curve25519.PointSize:],
+		}
+	case SlotShares:
+		if len(payload) != shareSlotSize || payload[0] == 0 {
+			return KeySlot{}, false, ErrKeySlot
+		}
+
+		slot = KeySlot{
+			Kind:      SlotShares,
+			Threshold: payload[0],
+			SealedKey: payload[1:],
+		}
+	case SlotHybrid:
+		if len(payload) != hybridSlotSize {
+			return KeySlot{}, false, ErrKeySlot
+		}
+
+		kem := payload[curve25519.PointSize:]
+		slot = KeySlot{
+			Kind:          SlotHybrid,
+			EphemeralKey:  payload[:curve25519.PointSize],
+			KEMCiphertext: kem[:mlkem.CiphertextSize768],
+			SealedKey:     kem[mlkem.CiphertextSize768:],
+		}
+	default:
+		return KeySlot{}, false, nil
+	}
+
+	return slot, true, nil
+}
@@ -0,0 +1,82 @@
+package zar
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+var testKDFParams = KDFParams{Time: 1, Memory: 1 << 10, Threads: 1}
+
+func TestKeySlots(t *testing.T) {
+	escrow := []byte("escrow password")
+
+	archive, err := encodeArchive(WithStreaming(), WithKDFParams(testKDFParams), WithKeySlot(escrow, testKDFParams))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range [][]byte{testArchiveKey, escrow} {
+		d, err := NewDecoder(bytes.NewReader(archive), key, int64(len(archive)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(d.KeySlots()) != 2 {
+			t.Fatalf("expected 2 key slots got %d", len(d.KeySlots()))
+		}
+
+		r, err := d.Open("test2.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if buf, _ := io.ReadAll(r); string(buf) != "another file" {
+			t.Fatalf("unexpected contents %q", buf)
+		}
+	}
+
+	s, err := NewStreamReader(bytes.NewReader(archive), escrow)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Next(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewDecoder(bytes.NewReader(archive), []byte("wrong"), int64(len(archive))); err != ErrNoKeySlot {
+		t.Fatalf("expected %q got %v", ErrNoKeySlot, err)
+	}
+}
+
+func TestPasswordSlotLimit(t *testing.T) {
+	opts := []Option{WithKDFParams(testKDFParams)}
+	for i := 0; i < MaxPasswordSlots; i++ {
+		opts = append(opts, WithKeySlot([]byte("password"), testKDFParams))
+	}
+
+	if _, err := New(bytes.NewBuffer(nil), testArchiveKey, opts...); err != ErrKeySlots {
+		t.Fatalf("expected %q got %v", ErrKeySlots, err)
+	}
+
+	// a crafted archive with too many password slots is refused before any
+	// key derivation runs
+	slots := make([]KeySlot, MaxPasswordSlots+1)
+	for i := range slots {
+		slots[i] = KeySlot{
+			Kind:      SlotPassword,
+			KDF:       testKDFParams,
+			Salt:      make([]byte, saltSize),
+			SealedKey: make([]byte, sealedKeySize),
+		}
+	}
+
+	if _, _, err := readKeySlots(bytes.NewReader(marshalKeySlots(slots))); err != ErrKeySlots {
+		t.Fatalf("expected %q got %v", ErrKeySlots, err)
+	}
+
+	if _, _, err := readKeySlots(bytes.NewReader(marshalKeySlots(slots[:MaxPasswordSlots]))); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,119 @@
+package zar
+
+import (
+	"errors"
+
+	"github.com/andybalholm/brotli"
+	"golang.org/x/crypto/curve25519"
+)
+
+// DefaultBlockSize is the target size of the uncompressed contents of a
+// compression block
+const DefaultBlockSize = 1 << 20
+
+var (
+	// ErrBlockSize is returned when a compression block target size is
+	// not positive
+	ErrBlockSize = errors.New("invalid compression block size")
+	// ErrCompressionLevel is returned when the Brotli level is out of range
+	ErrCompressionLevel = errors.New("invalid compression level")
+)
+
+// Option configures an Encoder when it is created with New
+type Option func(*Encoder) error
+
+// WithBlockSize sets the target size of a compression block. Files are
+// accumulated into the same block until its uncompressed size reaches
+// the target, at which point the block is sealed.
+//
+// A file larger than the target will occupy a block of its own.
+func WithBlockSize(size int) Option {
+	return func(e *Encoder) error {
+		if size < 1 {
+			return ErrBlockSize
+		}
+
+		e.blockSize = uint64(size)
+		return nil
+	}
+}
+
+// WithCompressionLevel sets the Brotli compression level used for the
+// compression blocks and the almanac
+func WithCompressionLevel(level int) Option {
+	return func(e *Encoder) error {
+		if level < brotli.BestSpeed || level > brotli.BestCompression {
+			return ErrCompressionLevel
+		}
+
+		e.compressionLevel = level
+		return nil
+	}
+}
+
+// WithCipherSuite sets the cipher suite used to encrypt the body, one of
+// CipherAES256CTR, CipherAES256GCM or CipherXChaCha20Poly1305
+func WithCipherSuite(suite uint8) Option {
+	return func(e *Encoder) error {
+		if suite != CipherAES256CTR && !chunkedSuite(suite) {
+			return ErrUnsupportedCipher
+		}
+
+		e.header.CipherSuite = suite
+		return nil
+	}
+}
+
+// WithKDFParams sets the Argon2id parameters used to derive the key of the
+// password passed to New, such as KDFInteractive, KDFModerate or
+// KDFSensitive. The parameters are recorded in the password's key slot.
+func WithKDFParams(params KDFParams) Option {
+	return func(e *Encoder) error {
+		if err := params.validate(); err != nil {
+			return err
+		}
+
+		e.kdf = params
+		return nil
+	}
+}
+
+// WithKeySlot adds a key slot for an additional password, the archive can be
+// opened with either the password passed to New or any added with
+// WithKeySlot
+func WithKeySlot(password []byte, params KDFParams) Option {
+	return func(e *Encoder) error {
+		if err := params.validate(); err != nil {
+			return err
+		}
+
+		e.passwords = append(e.passwords, slotPassword{password, params})
+		return nil
+	}
+}
+
+// WithRecipient seals the archive's data key for an X25519 public key, the
+// archive can then be opened by passing the matching private key to
+// NewDecoder
+func WithRecipient(publicKey []byte) Option {
+	return func(e *Encoder) error {
+		if len(publicKey) != curve25519.PointSize {
+			return ErrRecipientKey
+		}
+
+		e.recipients = append(e.recipients, publicKey)
+		return nil
+	}
+}
+
+// DecoderOption configures a Decoder when it is created with NewDecoder
+type DecoderOption func(*Decoder) error
+
+// WithVerification makes Extract authenticate the whole archive with Verify
+// before any file is written
+func WithVerification() DecoderOption {
+	return func(d *Decoder) error {
+		d.verify = true
+		return nil
+	}
+}
@@ -0,0 +1,166 @@
+package zar
+
+import (
+	"crypto/ed25519"
+	"crypto/sha512"
+	"errors"
+	"io"
+	"math/bits"
+	"sort"
+)
+
+// Padding
+//
+// Padding hides the exact length of the compressed contents. It is written
+// as zero bytes after the compressed data of a section, inside the
+// authenticated ciphertext, and is skipped by the decoder as decompression
+// stops at the end of the compressed data.
+//
+// PaddingPadme pads each compression block to a PADMÉ length, which leaks
+// O(log log n) bits of the length at a cost of at most 12% overhead.
+//
+// WithArchiveBuckets pads the almanac so the body and trailer fill a multiple
+// of the bucket size, hiding the size of the archive within its bucket. The
+// header, key slots and nonce in front of the body are not padded, as their
+// size depends only on how the archive is unlocked.
+
+const (
+	// PaddingNone leaves compression blocks unpadded
+	PaddingNone uint8 = 0
+	// PaddingPadme pads compression blocks to a PADMÉ length
+	PaddingPadme uint8 = 1
+)
+
+var (
+	// ErrPadding is returned when a padding policy is not supported
+	ErrPadding = errors.New("unsupported padding policy")
+	// ErrPaddingBucket is returned when the archive bucket size is not a
+	// positive multiple of 16 bytes
+	ErrPaddingBucket = errors.New("invalid padding bucket size")
+)
+
+// WithBlockPadding sets the padding policy of the compression blocks, either
+// PaddingNone or PaddingPadme
+func WithBlockPadding(policy uint8) Option {
+	return func(e *Encoder) error {
+		if policy != PaddingNone && policy != PaddingPadme {
+			return ErrPadding
+		}
+
+		e.blockPadding = policy
+		return nil
+	}
+}
+
+// WithArchiveBuckets pads the archive so the body and its trailer fill a
+// multiple of size bytes, which must be a multiple of 16
+func WithArchiveBuckets(size int64) Option {
+	return func(e *Encoder) error {
+		if size < 1 || size%padBlockSize != 0 {
+			return ErrPaddingBucket
+		}
+
+		e.bucket = uint64(size)
+		return nil
+	}
+}
+
+// padme returns the PADMÉ padded length of n
+func padme(n uint64) uint64 {
+	if n < 2 {
+		return n
+	}
+
+	e := bits.Len64(n) - 1
+	s := bits.Len64(uint64(e))
+	mask := uint64(1)<<(e-s) - 1
+
+	return (n + mask) &^ mask
+}
+
+// sealedSize returns the length of n bytes sealed in chunks with the AEAD
+// overhead, an empty final chunk is sealed when n fills every chunk
+func sealedSize(n uint64, overhead int) uint64 {
+	chunks := (n + ChunkSize - 1) / ChunkSize
+	if chunks == 0 {
+		chunks = 1
+	}
+
+	return n + chunks*uint64(overhead)
+}
+
+// blockPaddingSize returns the padding of a compression block of n
+// compressed bytes
+func (e *Encoder) blockPaddingSize(n uint64) uint64 {
+	if e.blockPadding == PaddingPadme {
+		return padme(n) - n
+	}
+
+	return 0
+}
+
+// bucketPaddingSize returns the padding appended to the almanac, which
+// starts at almanacOffset and is n compressed bytes long, so the body and
+// trailer fill a multiple of the bucket size
+func (e *Encoder) bucketPaddingSize(almanacOffset, n uint64) uint64 {
+	trailer := uint64(sha512.Size)
+	if e.signingKey != nil {
+		trailer += ed25519.SignatureSize
+	}
+
+	// size returns the length of the body and trailer for the padding
+	size := func(padding uint64) uint64 {
+		almanac := n + padding
+		if e.sealed != nil {
+			almanac = sealedSize(almanac, e.sealed.aead.Overhead())
+		}
+
+		// the almanac's tag, its offset and at least one byte of PKCS#5
+		plain := almanacOffset + almanac + BlockTagSize + 8
+		plain += padBlockSize - plain%padBlockSize
+
+		if e.chunks != nil {
+			plain = sealedSize(plain, e.chunks.aead.Overhead())
+		}
+
+		return plain + trailer
+	}
+
+	// sizes grow with the padding but skip the lengths where a chunk
+	// overhead is added, in which case the next bucket is tried
+	for target := (size(0) + e.bucket - 1) / e.bucket * e.bucket; ; target += e.bucket {
+		padding := uint64(sort.Search(int(target), func(i int) bool {
+			return size(uint64(i)) >= target
+		}))
+
+		if size(padding) == target {
+			return padding
+		}
+	}
+}
+
+// writePadding writes n zero bytes to w
+func writePadding(w io.Writer, n uint64) error {
+	_, err := io.CopyN(w, zeroReader{}, int64(n))
+	return err
+}
+
+// zeroReader reads zero bytes
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// countWriter counts the bytes written to w
+type countWriter struct {
+	w io.Writer
+	n uint64
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += uint64(n)
+	return n, err
+}
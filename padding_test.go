@@ -0,0 +1,164 @@
+package zar
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"io"
+	"testing"
+)
+
+func TestPadme(t *testing.T) {
+	for _, c := range []struct {
+		n, padded uint64
+	}{
+		{0, 0},
+		{1, 1},
+		{9, 10},
+		{100, 104},
+		{1000, 1024},
+		{1 << 20, 1 << 20},
+	} {
+		if padded := padme(c.n); padded != c.padded {
+			t.Fatalf("padme(%d): expected %d got %d", c.n, c.padded, padded)
+		}
+	}
+
+	for n := uint64(1); n < 1<<16; n++ {
+		if padded := padme(n); padded < n || float64(padded-n) > float64(n)*0.12 {
+			t.Fatalf("padme(%d): %d exceeds the overhead", n, padded)
+		}
+	}
+}
+
+func TestPadding(t *testing.T) {
+	_, signingKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		opts      []Option
+		bucket    uint64
+		streaming bool
+	}{
+		{opts: []Option{WithBlockPadding(PaddingPadme)}},
+		{opts: []Option{WithBlockPadding(PaddingPadme), WithArchiveBuckets(4096), WithStreaming()}, bucket: 4096, streaming: true},
+		{opts: []Option{WithArchiveBuckets(4096), WithCipherSuite(CipherAES256GCM)}, bucket: 4096},
+		{opts: []Option{WithArchiveBuckets(1 << 20), WithCipherSuite(CipherXChaCha20Poly1305), WithSigningKey(signingKey)}, bucket: 1 << 20},
+		{opts: []Option{WithArchiveBuckets(16), WithFileKeys(), WithBlockPadding(PaddingPadme), WithCipherSuite(CipherAES256GCM)}, bucket: 16},
+	} {
+		archive, err := encodeArchive(c.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		d, err := NewDecoder(bytes.NewReader(archive), testArchiveKey, int64(len(archive)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := d.Verify(); err != nil {
+			t.Fatal(err)
+		}
+
+		f, err := d.Open("some/file.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		contents, err := io.ReadAll(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(contents) != "mid 18th Century" {
+			t.Fatalf("unexpected contents %q", contents)
+		}
+
+		if c.bucket > 0 {
+			if n := uint64(len(archive)) - uint64(d.bodyOffset); n%c.bucket != 0 {
+				t.Fatalf("body of %d bytes does not fill a %d byte bucket", n, c.bucket)
+			}
+		}
+
+		if c.streaming {
+			s, err := NewStreamReader(bytes.NewReader(archive), testArchiveKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for {
+				if _, err := s.Next(); err == io.EOF {
+					break
+				} else if err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+
+	for _, size := range []int64{0, -16, 100} {
+		if _, err := encodeArchive(WithArchiveBuckets(size)); err != ErrPaddingBucket {
+			t.Fatalf("expected %q got %v", ErrPaddingBucket, err)
+		}
+	}
+
+	if _, err := encodeArchive(WithBlockPadding(2)); err != ErrPadding {
+		t.Fatalf("expected %q got %v", ErrPadding, err)
+	}
+}
+
+func TestBlockPadding(t *testing.T) {
+	for _, opts := range [][]Option{
+		nil,
+		{WithBlockSize(20)},
+		{WithCipherSuite(CipherAES256GCM)},
+	} {
+		unpadded := blockLengths(t, opts...)
+		padded := blockLengths(t, append(opts, WithBlockPadding(PaddingPadme))...)
+
+		if len(padded) != len(unpadded) {
+			t.Fatalf("expected %d blocks got %d", len(unpadded), len(padded))
+		}
+
+		// compression is deterministic so each block held the same
+		// compressed data before padding
+		for i, n := range unpadded {
+			if padded[i] != padme(n) {
+				t.Fatalf("block %d: expected %d bytes got %d", i, padme(n), padded[i])
+			}
+		}
+	}
+}
+
+// blockLengths encodes the test archive and returns the length of each
+// block's ciphertext without its tag
+func blockLengths(t *testing.T, opts ...Option) []uint64 {
+	t.Helper()
+
+	archive, err := encodeArchive(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := NewDecoder(bytes.NewReader(archive), testArchiveKey, int64(len(archive)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.open(); err != nil {
+		t.Fatal(err)
+	}
+
+	var lengths []uint64
+	files := d.almanac.Files
+	for i := range files {
+		if i > 0 && files[i].Offset == files[i-1].Offset {
+			continue
+		}
+
+		lengths = append(lengths, d.blockLength(files, i)-BlockTagSize)
+	}
+
+	return lengths
+}
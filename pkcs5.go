@@ -1,5 +1,9 @@
 package zar
 
+// padBlockSize is the multiple the plaintext of the body is padded to, it is
+// the same for every cipher suite so the layout of the body does not change
+const padBlockSize = 16
+
 //pkcs5 returns the padding buffer based on weather or not it is required
 // Maximum blocksize is 255
 func pkcs5[num int64 | int | int32 | uint32 | uint64](actual num, blockSize num) []byte {
@@ -0,0 +1,111 @@
+package zar
+
+import (
+	"bytes"
+	"io"
+)
+
+// Reader provides sequential access to the files of an archive, in the style
+// of archive/tar.
+//
+// Files are returned in the order of the almanac and the body is read once
+// from front to back. Each compression block is authenticated before any of
+// its files are returned.
+type Reader struct {
+	d *Decoder
+	// stream reads the body from the start, as returned by readAt
+	stream io.Reader
+	// pos is the position of stream within the body
+	pos uint64
+
+	// next is the index of the file returned by the next call to Next
+	next int
+	// block is the current compression block and blockEnd the index after
+	// its last file
+	block    Block
+	blockEnd int
+	// offset is the start of the next file within the block
+	offset uint64
+
+	current *bytes.Reader
+}
+
+// Reader returns a sequential reader over the archive's files. The almanac
+// is read and authenticated before returning.
+func (d *Decoder) Reader() (*Reader, error) {
+	if err := d.open(); err != nil {
+		return nil, err
+	}
+
+	return &Reader{
+		d:      d,
+		stream: d.body.reader(),
+	}, nil
+}
+
+// Next advances to the next file in the archive, io.EOF is returned at the
+// end of the archive.
+func (r *Reader) Next() (*File, error) {
+	files := r.d.almanac.Files
+	if r.next >= len(files) {
+		r.current = nil
+		return nil, io.EOF
+	}
+
+	if r.next >= r.blockEnd {
+		if err := r.readBlock(); err != nil {
+			return nil, err
+		}
+	}
+
+	f := &files[r.next]
+	r.current = bytes.NewReader(r.block[r.offset:f.End(r.offset)])
+	r.offset = f.End(r.offset)
+	r.next++
+
+	return f, nil
+}
+
+// Read reads from the current file, io.EOF is returned at the end of the
+// file or if Next has not been called.
+func (r *Reader) Read(p []byte) (int, error) {
+	if r.current == nil {
+		return 0, io.EOF
+	}
+
+	return r.current.Read(p)
+}
+
+// readBlock reads the block beginning with the next file from the stream
+func (r *Reader) readBlock() error {
+	files := r.d.almanac.Files
+	f := &files[r.next]
+
+	// skip any ciphertext between the current position and the block
+	if f.Offset > r.pos {
+		if _, err := io.CopyN(io.Discard, r.stream, int64(f.Offset-r.pos)); err != nil {
+			return err
+		}
+
+		r.pos = f.Offset
+	}
+
+	end, size := blockEnd(files, r.next)
+
+	raw := make([]byte, r.d.blockLength(files, r.next))
+	if _, err := io.ReadFull(r.stream, raw); err != nil {
+		return err
+	}
+
+	block, err := r.d.decodeBlock(f, raw, size)
+	if err != nil {
+		return err
+	}
+
+	r.pos += uint64(len(raw))
+	r.block = block
+	r.blockEnd = end
+	r.offset = 0
+
+	return nil
+}
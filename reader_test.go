@@ -0,0 +1,54 @@
+package zar
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestReader(t *testing.T) {
+	archive, err := encodeArchive(WithBlockSize(20))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := NewDecoder(bytes.NewReader(archive), testArchiveKey, int64(len(archive)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := d.Reader()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []struct{ name, contents string }{
+		{"test.txt", "my file contents..."},
+		{"test2.txt", "another file"},
+		{"some/file.txt", "mid 18th Century"},
+	}
+
+	for _, e := range expected {
+		f, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if f.Name != e.name {
+			t.Fatalf("expected %q got %q", e.name, f.Name)
+		}
+
+		contents, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(contents) != e.contents {
+			t.Fatalf("%s: expected %q got %q", e.name, e.contents, contents)
+		}
+	}
+
+	if _, err := r.Next(); err != io.EOF {
+		t.Fatalf("expected EOF got %v", err)
+	}
+}
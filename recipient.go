@@ -0,0 +1,116 @@
+package zar
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"io"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Recipients
+//
+// A recipient slot seals the data key for an X25519 public key, so an archive
+// can be written by a party which holds no secret able to decrypt it. An
+// ephemeral key pair is generated for each slot and the key encryption key is
+// derived from the shared secret with HKDF-SHA256, bound to both public keys:
+//
+//	X25519 Slot
+//	    - Ephemeral Public Key (32 bytes)
+//	    - Sealed Data Key (48 bytes)
+//
+// The archive is opened by passing the recipient's private key to NewDecoder
+// in place of a password.
+
+const (
+	// x25519SlotSize is the length of a recipient slot's payload
+	x25519SlotSize = curve25519.PointSize + sealedKeySize
+
+	labelX25519 = "zar x25519"
+)
+
+// ErrRecipientKey is returned when an X25519 public key is malformed
+var ErrRecipientKey = errors.New("invalid recipient public key")
+
+// GenerateRecipientKey generates an X25519 key pair. The public key is given
+// to WithRecipient and the private key opens the archive.
+func GenerateRecipientKey() (publicKey, privateKey []byte, err error) {
+	privateKey = make([]byte, curve25519.ScalarSize)
+	if _, err := io.ReadFull(rand.Reader, privateKey); err != nil {
+		return nil, nil, err
+	}
+
+	publicKey, err = curve25519.X25519(privateKey, curve25519.Basepoint)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return publicKey, privateKey, nil
+}
+
+// newX25519Slot seals the data key into a new slot for the public key
+func newX25519Slot(publicKey, dataKey, header []byte) (KeySlot, error) {
+	if len(publicKey) != curve25519.PointSize {
+		return KeySlot{}, ErrRecipientKey
+	}
+
+	ephemeralPublic, ephemeral, err := GenerateRecipientKey()
+	if err != nil {
+		return KeySlot{}, err
+	}
+
+	shared, err := curve25519.X25519(ephemeral, publicKey)
+	if err != nil {
+		return KeySlot{}, ErrRecipientKey
+	}
+
+	kek, err := x25519KEK(shared, ephemeralPublic, publicKey)
+	if err != nil {
+		return KeySlot{}, err
+	}
+
+	aead, err := slotCipher(kek)
+	if err != nil {
+		return KeySlot{}, err
+	}
+
+	return KeySlot{
+		Kind:         SlotX25519,
+		EphemeralKey: ephemeralPublic,
+		SealedKey:    aead.Seal(nil, make([]byte, aead.NonceSize()), dataKey, header),
+	}, nil
+}
+
+// x25519Unwrap derives the key encryption key of a slot from the recipient's
+// private key
+func x25519Unwrap(privateKey, ephemeralPublic []byte) ([]byte, error) {
+	if len(privateKey) != curve25519.ScalarSize {
+		return nil, ErrRecipientKey
+	}
+
+	publicKey, err := curve25519.X25519(privateKey, curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+
+	shared, err := curve25519.X25519(privateKey, ephemeralPublic)
+	if err != nil {
+		return nil, err
+	}
+
+	return x25519KEK(shared, ephemeralPublic, publicKey)
+}
+
+// x25519KEK derives the key encryption key from the shared secret
+func x25519KEK(shared, ephemeralPublic, publicKey []byte) ([]byte, error) {
+	info := append(append([]byte(labelX25519), ephemeralPublic...), publicKey...)
+
+	kek := make([]byte, 32)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared, nil, info), kek); err != nil {
+		return nil, err
+	}
+
+	return kek, nil
+}
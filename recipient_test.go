@@ -0,0 +1,61 @@
+package zar
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRecipient(t *testing.T) {
+	publicKey, privateKey, err := GenerateRecipientKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, other, err := GenerateRecipientKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output := bytes.NewBuffer(nil)
+	archive, err := New(output, nil, WithRecipient(publicKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := archive.Add("test.txt", 0, bytes.NewReader([]byte("contents"))); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := output.Bytes()
+
+	d, err := NewDecoder(bytes.NewReader(buf), privateKey, int64(len(buf)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if slots := d.KeySlots(); len(slots) != 1 || slots[0].Kind != SlotX25519 {
+		t.Fatalf("expected a single recipient slot got %+v", slots)
+	}
+
+	if err := d.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range [][]byte{other, testArchiveKey} {
+		if _, err := NewDecoder(bytes.NewReader(buf), key, int64(len(buf))); err != ErrNoKeySlot {
+			t.Fatalf("expected %q got %v", ErrNoKeySlot, err)
+		}
+	}
+
+	if _, err := New(bytes.NewBuffer(nil), nil); err != ErrKeySlots {
+		t.Fatalf("expected %q got %v", ErrKeySlots, err)
+	}
+
+	if _, err := New(bytes.NewBuffer(nil), nil, WithRecipient(publicKey[:8])); err != ErrRecipientKey {
+		t.Fatalf("expected %q got %v", ErrRecipientKey, err)
+	}
+}
@@ -0,0 +1,84 @@
+package zar
+
+import "io"
+
+// ReadWriterAt is an archive which can be modified in place, such as an
+// *os.File
+type ReadWriterAt interface {
+	io.ReaderAt
+	io.WriterAt
+}
+
+// Rekey changes the password of the key slot unlocked by oldKey to newKey.
+// Only the key slot is rewritten in place, the body and its tags are left
+// untouched as the data key does not change. The slot keeps its Argon2id
+// parameters but is given a new salt. ErrKeySlot is returned if oldKey
+// unlocks a recipient slot rather than a password slot.
+//
+// The other key slots continue to open the archive. If the write is
+// interrupted the rewritten slot may be left unusable, so an archive with a
+// single key slot should be backed up or copied with RekeyTo instead.
+//
+// The options are passed to NewDecoder. If the archive requires keyfiles the
+// new password is combined with the keyfiles given with WithDecoderKeyfiles.
+func Rekey(f ReadWriterAt, size int64, oldKey, newKey []byte, opts ...DecoderOption) error {
+	d, slot, err := rekeySlot(f, size, oldKey, newKey, opts)
+	if err != nil {
+		return err
+	}
+
+	old := d.slots[d.unlocked]
+	_, err = f.WriteAt(slot.payload(), HeaderSize+old.offset)
+	return err
+}
+
+// RekeyTo writes a copy of the archive to w with the password of the key
+// slot unlocked by oldKey changed to newKey. The body is copied without
+// being decrypted.
+func RekeyTo(w io.Writer, r io.ReaderAt, size int64, oldKey, newKey []byte, opts ...DecoderOption) error {
+	d, slot, err := rekeySlot(r, size, oldKey, newKey, opts)
+	if err != nil {
+		return err
+	}
+
+	old := d.slots[d.unlocked]
+
+	// everything before the slot's payload
+	if _, err := io.Copy(w, io.NewSectionReader(r, 0, HeaderSize+old.offset)); err != nil {
+		return err
+	}
+
+	if _, err := w.Write(slot.payload()); err != nil {
+		return err
+	}
+
+	// the remaining slots, nonce and body
+	start := HeaderSize + old.offset + passwordSlotSize
+	_, err = io.Copy(w, io.NewSectionReader(r, start, size-start))
+	return err
+}
+
+// rekeySlot unlocks the archive with oldKey and seals its data key into a
+// replacement for the unlocked slot
+func rekeySlot(r io.ReaderAt, size int64, oldKey, newKey []byte, opts []DecoderOption) (*Decoder, KeySlot, error) {
+	d, err := NewDecoder(r, oldKey, size, opts...)
+	if err != nil {
+		return nil, KeySlot{}, err
+	}
+
+	old := d.slots[d.unlocked]
+	if old.Kind != SlotPassword {
+		return nil, KeySlot{}, ErrKeySlot
+	}
+
+	if d.header.RequiredFeatures.Has(FeatureKeyfile) {
+		newKey = mixKeyfiles(newKey, d.keyfiles)
+	}
+
+	slot, err := newPasswordSlot(newKey, d.keys.master, old.KDF, d.header.Marshal())
+	if err != nil {
+		return nil, KeySlot{}, err
+	}
+
+	return d, slot, nil
+}
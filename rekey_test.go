@@ -0,0 +1,76 @@
+package zar
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRekey(t *testing.T) {
+	escrow := []byte("escrow password")
+	newKey := []byte("rotated password")
+
+	archive, err := encodeArchive(WithKDFParams(testKDFParams), WithKeySlot(escrow, testKDFParams))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "archive.zar")
+	if err := os.WriteFile(path, archive, filePermissions); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := Rekey(f, int64(len(archive)), testArchiveKey, newKey); err != nil {
+		t.Fatal(err)
+	}
+
+	rekeyed, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectRekeyed(t, rekeyed, testArchiveKey, newKey, escrow)
+
+	buf := bytes.NewBuffer(nil)
+	if err := RekeyTo(buf, bytes.NewReader(archive), int64(len(archive)), escrow, newKey); err != nil {
+		t.Fatal(err)
+	}
+
+	expectRekeyed(t, buf.Bytes(), escrow, newKey, testArchiveKey)
+
+	if err := Rekey(f, int64(len(archive)), []byte("wrong"), newKey); err != ErrNoKeySlot {
+		t.Fatalf("expected %q got %v", ErrNoKeySlot, err)
+	}
+}
+
+// expectRekeyed checks the archive opens with the new key and the key of the
+// other slot but no longer with the old key
+func expectRekeyed(t *testing.T, archive, oldKey, newKey, otherKey []byte) {
+	t.Helper()
+
+	for _, key := range [][]byte{newKey, otherKey} {
+		d, err := NewDecoder(bytes.NewReader(archive), key, int64(len(archive)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := d.Verify(); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := d.Open("test.txt"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := NewDecoder(bytes.NewReader(archive), oldKey, int64(len(archive))); err != ErrNoKeySlot {
+		t.Fatalf("expected %q got %v", ErrNoKeySlot, err)
+	}
+}
@@ -0,0 +1,286 @@
+package zar
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Key shares
+//
+// WithShares seals the data key into a share slot with a random share key,
+// which is split with Shamir's secret sharing so that any threshold of the
+// shares recover it. The slot records the threshold:
+//
+//	Share Slot
+//	    - Threshold (1 byte)
+//	    - Sealed Data Key (48 bytes)
+//
+// Each share is exported as a line of text holding the share set's random
+// identifier, the threshold, the share's index, the share and a checksum of
+// the preceding fields:
+//
+//	zar-share-1-<set id>-<threshold>-<index>-<share>-<checksum>
+//
+// CombineShares recovers the share key from the text, which is then passed
+// to NewDecoder in place of a password.
+
+const (
+	// SlotShares is a key slot unlocked by a share key recovered from a
+	// threshold of key shares
+	SlotShares uint8 = 3
+)
+
+const (
+	sharePrefix = "zar-share-1"
+	// shareSetIDSize is the length of the identifier shared by the shares
+	// of one split
+	shareSetIDSize = 4
+	// shareChecksumSize is the length of the truncated SHA-256 checksum
+	shareChecksumSize = 4
+	// shareSlotSize is the length of a share slot's payload
+	shareSlotSize = 1 + sealedKeySize
+
+	labelShares = "zar shares"
+)
+
+var (
+	// ErrShareThreshold is returned when the threshold or number of shares
+	// is out of range
+	ErrShareThreshold = errors.New("invalid key share threshold")
+	// ErrShare is returned when a key share is malformed or its checksum
+	// does not match
+	ErrShare = errors.New("invalid key share")
+	// ErrShareSet is returned when the shares belong to different splits or
+	// a share is repeated
+	ErrShareSet = errors.New("key shares do not belong to the same set")
+	// ErrSharesTooFew is returned when fewer shares than the threshold are
+	// combined
+	ErrSharesTooFew = errors.New("not enough key shares")
+)
+
+// WithShares splits the archive's key into n shares, any threshold of which
+// open the archive. The shares are returned by Encoder.Shares.
+func WithShares(threshold, n int) Option {
+	return func(e *Encoder) error {
+		if threshold < 1 || threshold > n || n > 255 {
+			return ErrShareThreshold
+		}
+
+		e.shareThreshold = threshold
+		e.shareCount = n
+		return nil
+	}
+}
+
+// Shares returns the key shares of an archive written with WithShares. Each
+// share should be given to a different custodian.
+func (e *Encoder) Shares() []string {
+	return e.shares
+}
+
+// newShareSlot seals the data key into a share slot for the share key
+func newShareSlot(shareKey, dataKey []byte, threshold int, header []byte) (KeySlot, error) {
+	aead, err := slotCipher(shareKEK(shareKey))
+	if err != nil {
+		return KeySlot{}, err
+	}
+
+	return KeySlot{
+		Kind:      SlotShares,
+		Threshold: uint8(threshold),
+		SealedKey: aead.Seal(nil, make([]byte, aead.NonceSize()), dataKey, header),
+	}, nil
+}
+
+// shareKEK derives the key encryption key of a share slot
+func shareKEK(shareKey []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte(labelShares))
+	h.Write(shareKey)
+
+	return h.Sum(nil)
+}
+
+// splitShares splits the secret into n text shares
+func splitShares(secret []byte, threshold, n int) ([]string, error) {
+	id := make([]byte, shareSetIDSize)
+	if _, err := io.ReadFull(rand.Reader, id); err != nil {
+		return nil, err
+	}
+
+	shares := make([][]byte, n)
+	for i := range shares {
+		shares[i] = make([]byte, len(secret))
+	}
+
+	// a random polynomial of degree threshold-1 for each byte of the
+	// secret, with the byte as the constant term
+	coefficients := make([]byte, threshold)
+	for b, s := range secret {
+		if _, err := io.ReadFull(rand.Reader, coefficients[1:]); err != nil {
+			return nil, err
+		}
+
+		coefficients[0] = s
+
+		for i := range shares {
+			shares[i][b] = gfEval(coefficients, byte(i+1))
+		}
+	}
+
+	text := make([]string, n)
+	for i, share := range shares {
+		text[i] = encodeShare(id, threshold, i+1, share)
+	}
+
+	return text, nil
+}
+
+// CombineShares recovers the share key from at least the threshold number of
+// shares, the key is passed to NewDecoder to open the archive
+func CombineShares(shares []string) ([]byte, error) {
+	if len(shares) == 0 {
+		return nil, ErrSharesTooFew
+	}
+
+	var (
+		id        string
+		threshold int
+		xs        []byte
+		ys        [][]byte
+	)
+
+	for _, text := range shares {
+		shareID, shareThreshold, x, y, err := decodeShare(text)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(ys) == 0 {
+			id, threshold = shareID, shareThreshold
+		} else if shareID != id || shareThreshold != threshold || len(y) != len(ys[0]) {
+			return nil, ErrShareSet
+		}
+
+		for _, seen := range xs {
+			if seen == x {
+				return nil, ErrShareSet
+			}
+		}
+
+		xs = append(xs, x)
+		ys = append(ys, y)
+	}
+
+	if len(xs) < threshold {
+		return nil, ErrSharesTooFew
+	}
+
+	xs, ys = xs[:threshold], ys[:threshold]
+
+	// Lagrange interpolation at zero
+	secret := make([]byte, len(ys[0]))
+	for i := range xs {
+		basis := byte(1)
+		for j := range xs {
+			if i != j {
+				basis = gfMul(basis, gfDiv(xs[j], xs[j]^xs[i]))
+			}
+		}
+
+		for b := range secret {
+			secret[b] ^= gfMul(ys[i][b], basis)
+		}
+	}
+
+	return secret, nil
+}
+
+// encodeShare formats a share as text with a checksum
+func encodeShare(id []byte, threshold, x int, share []byte) string {
+	body := fmt.Sprintf("%s-%x-%d-%d-%x", sharePrefix, id, threshold, x, share)
+	return body + "-" + shareChecksum(body)
+}
+
+// decodeShare parses and checks a text share
+func decodeShare(text string) (id string, threshold int, x byte, y []byte, err error) {
+	text = strings.TrimSpace(text)
+
+	i := strings.LastIndexByte(text, '-')
+	if i < 0 {
+		return "", 0, 0, nil, ErrShare
+	}
+
+	body, checksum := text[:i], text[i+1:]
+	if subtle.ConstantTimeCompare([]byte(shareChecksum(body)), []byte(checksum)) != 1 {
+		return "", 0, 0, nil, ErrShare
+	}
+
+	if !strings.HasPrefix(body, sharePrefix+"-") {
+		return "", 0, 0, nil, ErrShare
+	}
+
+	fields := strings.Split(strings.TrimPrefix(body, sharePrefix+"-"), "-")
+	if len(fields) != 4 {
+		return "", 0, 0, nil, ErrShare
+	}
+
+	var index int
+	if _, err := fmt.Sscanf(fields[1]+" "+fields[2], "%d %d", &threshold, &index); err != nil {
+		return "", 0, 0, nil, ErrShare
+	}
+
+	y, err = hex.DecodeString(fields[3])
+	if err != nil || threshold < 1 || index < 1 || index > 255 || len(y) == 0 {
+		return "", 0, 0, nil, ErrShare
+	}
+
+	return fields[0], threshold, byte(index), y, nil
+}
+
+// shareChecksum returns the truncated SHA-256 of a share's text
+func shareChecksum(body string) string {
+	sum := sha256.Sum256([]byte(body))
+	return hex.EncodeToString(sum[:shareChecksumSize])
+}
+
+// gfEval evaluates the polynomial at x in GF(2^8)
+func gfEval(coefficients []byte, x byte) byte {
+	var y byte
+	for i := len(coefficients) - 1; i >= 0; i-- {
+		y = gfMul(y, x) ^ coefficients[i]
+	}
+
+	return y
+}
+
+// gfMul multiplies in GF(2^8) with the AES polynomial, without branching on
+// secret values
+func gfMul(a, b byte) byte {
+	var p byte
+	for i := 0; i < 8; i++ {
+		p ^= a & -(b & 1)
+		carry := -(a >> 7)
+		a = (a << 1) ^ (0x1b & carry)
+		b >>= 1
+	}
+
+	return p
+}
+
+// gfDiv divides in GF(2^8), b must not be zero
+func gfDiv(a, b byte) byte {
+	// b^254 is the inverse of b
+	inv := b
+	for i := 0; i < 6; i++ {
+		inv = gfMul(gfMul(inv, inv), b)
+	}
+
+	return gfMul(a, gfMul(inv, inv))
+}
@@ -0,0 +1,98 @@
+package zar
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestShares(t *testing.T) {
+	output := bytes.NewBuffer(nil)
+	archive, err := New(output, nil, WithShares(3, 5))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := archive.Add("test.txt", 0, bytes.NewReader([]byte("contents"))); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	shares := archive.Shares()
+	if len(shares) != 5 {
+		t.Fatalf("expected 5 shares got %d", len(shares))
+	}
+
+	buf := output.Bytes()
+
+	for _, subset := range [][]string{
+		shares[:3],
+		shares[2:],
+		{shares[4], shares[0], shares[2]},
+		shares,
+	} {
+		key, err := CombineShares(subset)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		d, err := NewDecoder(bytes.NewReader(buf), key, int64(len(buf)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := d.Verify(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := CombineShares(shares[:2]); err != ErrSharesTooFew {
+		t.Fatalf("expected %q got %v", ErrSharesTooFew, err)
+	}
+
+	if _, err := CombineShares([]string{shares[0], shares[1], shares[1]}); err != ErrShareSet {
+		t.Fatalf("expected %q got %v", ErrShareSet, err)
+	}
+
+	// a share from another split
+	other, err := New(bytes.NewBuffer(nil), nil, WithShares(3, 5))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := CombineShares([]string{shares[0], shares[1], other.Shares()[2]}); err != ErrShareSet {
+		t.Fatalf("expected %q got %v", ErrShareSet, err)
+	}
+
+	// a mistyped share fails its checksum
+	i := len(sharePrefix) + 10
+	corrupt := shares[0][:i] + strings.Map(func(r rune) rune {
+		if r == '0' {
+			return '1'
+		}
+		return '0'
+	}, shares[0][i:i+1]) + shares[0][i+1:]
+
+	if _, err := CombineShares([]string{corrupt, shares[1], shares[2]}); err != ErrShare {
+		t.Fatalf("expected %q got %v", ErrShare, err)
+	}
+
+	for _, n := range [][2]int{{0, 5}, {6, 5}, {2, 256}} {
+		if _, err := New(bytes.NewBuffer(nil), nil, WithShares(n[0], n[1])); err != ErrShareThreshold {
+			t.Fatalf("expected %q got %v", ErrShareThreshold, err)
+		}
+	}
+}
+
+func TestGaloisField(t *testing.T) {
+	for a := 0; a < 256; a++ {
+		for b := 1; b < 256; b++ {
+			if got := gfDiv(gfMul(byte(a), byte(b)), byte(b)); got != byte(a) {
+				t.Fatalf("%d * %d / %d = %d", a, b, b, got)
+			}
+		}
+	}
+}
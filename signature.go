@@ -0,0 +1,122 @@
+package zar
+
+import (
+	"crypto/ed25519"
+	"crypto/sha512"
+	"errors"
+)
+
+// Signatures
+//
+// An archive can be signed with Ed25519 to prove who wrote it, as the master
+// MAC only proves the writer held a key which every reader also holds. The
+// signature covers the header, a hash of the almanac and the master MAC:
+//
+//	Signed Message
+//	    - Label "zar signature"
+//	    - Header (16 bytes)
+//	    - SHA-512 of the Compressed Almanac (64 bytes)
+//	    - Master MAC (64 bytes)
+//
+// Archives written with WithSigningKey carry the signature as a trailer after
+// the master MAC, Encoder.Sign produces a detached signature instead.
+
+const (
+	// FeatureSignature marks an archive as carrying an Ed25519 signature
+	// after the master MAC
+	FeatureSignature Feature = 1 << 1
+)
+
+const labelSignature = "zar signature"
+
+var (
+	// ErrNoSignature is returned when verifying the signature of an archive
+	// which was not written with WithSigningKey
+	ErrNoSignature = errors.New("archive is not signed")
+	// ErrSignature is returned when a signature does not match the archive
+	ErrSignature = errors.New("signature verification failed")
+	// ErrSigningKey is returned when an Ed25519 private key is malformed
+	ErrSigningKey = errors.New("invalid signing key")
+	// ErrNotClosed is returned when signing an archive before Close
+	ErrNotClosed = errors.New("archive has not been closed")
+)
+
+// WithSigningKey signs the archive with the Ed25519 private key, the
+// signature is stored as a trailer after the master MAC
+func WithSigningKey(key ed25519.PrivateKey) Option {
+	return func(e *Encoder) error {
+		if len(key) != ed25519.PrivateKeySize {
+			return ErrSigningKey
+		}
+
+		e.header.RequiredFeatures |= FeatureSignature
+		e.signingKey = key
+		return nil
+	}
+}
+
+// signedMessage returns the message covered by the archive's signature
+func signedMessage(header, almanacHash, mac []byte) []byte {
+	msg := append([]byte(labelSignature), header...)
+	msg = append(msg, almanacHash...)
+	return append(msg, mac...)
+}
+
+// Sign returns a detached Ed25519 signature of the archive, it must be called
+// after Close
+func (e *Encoder) Sign(key ed25519.PrivateKey) ([]byte, error) {
+	if e.mac == nil {
+		return nil, ErrNotClosed
+	}
+
+	return ed25519.Sign(key, signedMessage(e.header.Marshal(), e.almanacHash, e.mac)), nil
+}
+
+// VerifySignature authenticates the whole archive with Verify and checks it
+// was signed by the holder of the private key matching pub.
+// ErrNoSignature is returned if the archive has no signature trailer.
+func (d *Decoder) VerifySignature(pub ed25519.PublicKey) error {
+	if !d.header.RequiredFeatures.Has(FeatureSignature) {
+		return ErrNoSignature
+	}
+
+	sig := make([]byte, ed25519.SignatureSize)
+	if _, err := readAtFull(d.r, sig, d.size-ed25519.SignatureSize); err != nil {
+		return err
+	}
+
+	return d.VerifyDetachedSignature(pub, sig)
+}
+
+// VerifyDetachedSignature authenticates the whole archive with Verify and
+// checks sig, as returned by Encoder.Sign, was made by the holder of the
+// private key matching pub
+func (d *Decoder) VerifyDetachedSignature(pub ed25519.PublicKey, sig []byte) error {
+	if err := d.Verify(); err != nil {
+		return err
+	}
+
+	if err := d.open(); err != nil {
+		return err
+	}
+
+	mac := make([]byte, sha512.Size)
+	if _, err := readAtFull(d.r, mac, d.size-d.trailerSize()); err != nil {
+		return err
+	}
+
+	if len(pub) != ed25519.PublicKeySize || !ed25519.Verify(pub, signedMessage(d.header.Marshal(), d.almanacHash, mac), sig) {
+		return ErrSignature
+	}
+
+	return nil
+}
+
+// trailerSize returns the length of the data following the body
+func (d *Decoder) trailerSize() int64 {
+	if d.header.RequiredFeatures.Has(FeatureSignature) {
+		return sha512.Size + ed25519.SignatureSize
+	}
+
+	return sha512.Size
+}
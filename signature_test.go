@@ -0,0 +1,111 @@
+package zar
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"io"
+	"testing"
+)
+
+func TestSignature(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	archive, err := encodeArchive(WithStreaming(), WithSigningKey(priv))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := NewDecoder(bytes.NewReader(archive), testArchiveKey, int64(len(archive)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.VerifySignature(pub); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.VerifySignature(other); err != ErrSignature {
+		t.Fatalf("expected %q got %v", ErrSignature, err)
+	}
+
+	if _, err := d.Open("test.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewStreamReader(bytes.NewReader(archive), testArchiveKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for {
+		if _, err := s.Next(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// modify the signature
+	archive[len(archive)-1] ^= 1
+
+	d, err = NewDecoder(bytes.NewReader(archive), testArchiveKey, int64(len(archive)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.VerifySignature(pub); err != ErrSignature {
+		t.Fatalf("expected %q got %v", ErrSignature, err)
+	}
+}
+
+func TestDetachedSignature(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output := bytes.NewBuffer(nil)
+	archive, err := New(output, testArchiveKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := archive.Sign(priv); err != ErrNotClosed {
+		t.Fatalf("expected %q got %v", ErrNotClosed, err)
+	}
+
+	if _, err := archive.Add("test.txt", 0, bytes.NewReader([]byte("contents"))); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	sig, err := archive.Sign(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := output.Bytes()
+	d, err := NewDecoder(bytes.NewReader(buf), testArchiveKey, int64(len(buf)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.VerifyDetachedSignature(pub, sig); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := d.VerifySignature(pub); err != ErrNoSignature {
+		t.Fatalf("expected %q got %v", ErrNoSignature, err)
+	}
+}
@@ -0,0 +1,602 @@
+package zar
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"io"
+	"math"
+
+	"github.com/andybalholm/brotli"
+)
+
+// Streamable archives
+//
+// When an archive is written with WithStreaming each compression block is
+// split into frames, so the end of a block can be found without the
+// almanac. The block's frames are followed by an end frame holding the
+// metadata of the files stored within it and then the block's tag. The
+// almanac is preceded by an almanac frame.
+//
+//	Frame
+//	    - Kind (1 byte)
+//	    - Length (4 bytes)
+//	    - Payload
+//
+// Frames are part of the plaintext, they are encrypted and authenticated by
+// the master MAC like the rest of the body.
+
+const (
+	// FeatureStreaming marks an archive as written with framed compression
+	// blocks which can be decoded from a non seekable reader
+	FeatureStreaming Feature = 1 << 0
+)
+
+const (
+	frameData    byte = 1
+	frameEnd     byte = 2
+	frameAlmanac byte = 3
+
+	frameHeaderSize = 5
+	// maxFrameSize is the largest payload of a data frame
+	maxFrameSize = 64 << 10
+
+	// DefaultMaxStreamBlock is the default limit on the compressed length of
+	// a block read by a StreamReader
+	DefaultMaxStreamBlock = 256 << 20
+)
+
+var (
+	// ErrNotStreamable is returned when decoding an archive from a stream
+	// which was not written with WithStreaming
+	ErrNotStreamable = errors.New("archive was not written for streaming")
+	// ErrFrame is returned when a frame is malformed
+	ErrFrame = errors.New("invalid frame")
+	// ErrStreamBlockSize is returned when a block read by a StreamReader is
+	// larger than its limit
+	ErrStreamBlockSize = errors.New("compression block exceeds the stream reader's limit")
+)
+
+// WithStreaming writes the archive so that it can be decoded from a non
+// seekable reader with NewStreamReader. The archive can still be opened with
+// NewDecoder.
+func WithStreaming() Option {
+	return func(e *Encoder) error {
+		e.header.RequiredFeatures |= FeatureStreaming
+		return nil
+	}
+}
+
+// frameWriter splits the compressed block into data frames
+type frameWriter struct {
+	w   io.Writer
+	buf []byte
+}
+
+func newFrameWriter(w io.Writer) *frameWriter {
+	return &frameWriter{
+		w:   w,
+		buf: make([]byte, 0, maxFrameSize),
+	}
+}
+
+func (f *frameWriter) Write(p []byte) (int, error) {
+	n := len(p)
+
+	for len(p) > 0 {
+		x := copy(f.buf[len(f.buf):cap(f.buf)], p)
+		f.buf = f.buf[:len(f.buf)+x]
+		p = p[x:]
+
+		if len(f.buf) == cap(f.buf) {
+			if err := f.Flush(); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	return n, nil
+}
+
+// Flush writes any buffered data as a frame
+func (f *frameWriter) Flush() error {
+	if len(f.buf) == 0 {
+		return nil
+	}
+
+	if err := writeFrame(f.w, frameData, f.buf); err != nil {
+		return err
+	}
+
+	f.buf = f.buf[:0]
+	return nil
+}
+
+func writeFrame(w io.Writer, kind byte, payload []byte) error {
+	header := make([]byte, frameHeaderSize)
+	header[0] = kind
+	binary.BigEndian.PutUint32(header[1:], uint32(len(payload)))
+
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+
+	_, err := w.Write(payload)
+	return err
+}
+
+// frameReader joins the payloads of consecutive data frames. Reading stops at
+// the first frame of any other kind.
+type frameReader struct {
+	r io.Reader
+	// remaining is the unread length of the current data frame
+	remaining uint32
+
+	// kind and length are set to the frame which ended the data frames
+	kind   byte
+	length uint32
+	done   bool
+}
+
+func (f *frameReader) Read(p []byte) (int, error) {
+	for f.remaining == 0 {
+		if f.done {
+			return 0, io.EOF
+		}
+
+		if err := f.readHeader(); err != nil {
+			// the data frames must be ended by another frame
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+
+			return 0, err
+		}
+	}
+
+	if uint32(len(p)) > f.remaining {
+		p = p[:f.remaining]
+	}
+
+	n, err := f.r.Read(p)
+	f.remaining -= uint32(n)
+
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return n, err
+}
+
+// readHeader reads the next frame header
+func (f *frameReader) readHeader() error {
+	header := make([]byte, frameHeaderSize)
+	if _, err := io.ReadFull(f.r, header); err != nil {
+		return err
+	}
+
+	length := binary.BigEndian.Uint32(header[1:])
+
+	switch header[0] {
+	case frameData:
+		if length > maxFrameSize {
+			return ErrFrame
+		}
+
+		f.remaining = length
+	case frameEnd, frameAlmanac:
+		f.kind = header[0]
+		f.length = length
+		f.done = true
+	default:
+		return ErrFrame
+	}
+
+	return nil
+}
+
+// marshalEntries encodes the metadata of the files within a block for its
+// end frame
+func marshalEntries(files []File) []byte {
+	buf := bytes.NewBuffer(nil)
+	b := make([]byte, 8)
+
+	binary.BigEndian.PutUint32(b, uint32(len(files)))
+	buf.Write(b[:4])
+
+	for _, f := range files {
+		binary.BigEndian.PutUint64(b, f.Size)
+		buf.Write(b)
+
+		binary.BigEndian.PutUint64(b, f.Modified)
+		buf.Write(b)
+
+		binary.BigEndian.PutUint16(b, uint16(len(f.Name)))
+		buf.Write(b[:2])
+		buf.WriteString(f.Name)
+	}
+
+	return buf.Bytes()
+}
+
+// unmarshalEntries decodes the metadata of an end frame
+func unmarshalEntries(buf []byte, offset uint64) ([]File, error) {
+	if len(buf) < 4 {
+		return nil, ErrFrame
+	}
+
+	count := binary.BigEndian.Uint32(buf)
+	buf = buf[4:]
+
+	var files []File
+	for i := uint32(0); i < count; i++ {
+		if len(buf) < 18 {
+			return nil, ErrFrame
+		}
+
+		nameLen := int(binary.BigEndian.Uint16(buf[16:]))
+		if len(buf) < 18+nameLen {
+			return nil, ErrFrame
+		}
+
+		files = append(files, File{
+			Size:     binary.BigEndian.Uint64(buf),
+			Modified: binary.BigEndian.Uint64(buf[8:]),
+			Name:     string(buf[18 : 18+nameLen]),
+			Offset:   offset,
+		})
+
+		buf = buf[18+nameLen:]
+	}
+
+	return files, nil
+}
+
+// StreamReader decodes an archive from a non seekable reader, such as a pipe
+// or network connection. The archive must have been written with
+// WithStreaming.
+//
+// Files are returned in the order they were written. Each compression block
+// is authenticated by its tag before it is decompressed, but
+// the archive as a whole is only authenticated once Next returns io.EOF.
+// Until then the caller must treat the output as unauthenticated, as the
+// stream may have been truncated or had blocks removed. ErrIntegrityFailed
+// is returned by Next if the master MAC does not match.
+type StreamReader struct {
+	// plain is the decrypted body
+	plain *countReader
+	// trailer withholds the master MAC from the ciphertext
+	trailer   *trailerReader
+	masterMac hash.Hash
+	// tag receives the ciphertext of the current block, or the plaintext for
+	// chunked cipher suites
+	tag  *tagWriter
+	keys *archiveKeys
+
+	block Block
+	files []File
+	// next is the index of the next file in files and offset its start
+	// within the block
+	next   int
+	offset uint64
+
+	current *bytes.Reader
+	done    bool
+
+	// maxBlock limits the compressed length of a block, which is held in
+	// memory until its tag has been checked
+	maxBlock int64
+	// keyfiles is the digest of the keyfiles given with WithStreamKeyfiles
+	keyfiles []byte
+}
+
+// StreamOption configures a StreamReader when it is created with
+// NewStreamReader
+type StreamOption func(*StreamReader) error
+
+// WithMaxStreamBlock limits the compressed length of a block, and of the
+// metadata of its files, read by a StreamReader. Each block is held in
+// memory until its tag has been checked, blocks larger than the limit fail
+// with ErrStreamBlockSize. The default is DefaultMaxStreamBlock.
+func WithMaxStreamBlock(size int64) StreamOption {
+	return func(s *StreamReader) error {
+		if size < 1 || size == math.MaxInt64 {
+			return ErrBlockSize
+		}
+
+		s.maxBlock = size
+		return nil
+	}
+}
+
+// NewStreamReader reads the archive header from r and derives the keys
+func NewStreamReader(r io.Reader, key []byte, opts ...StreamOption) (*StreamReader, error) {
+	s := &StreamReader{maxBlock: DefaultMaxStreamBlock}
+	for _, opt := range opts {
+		if err := opt(s); err != nil {
+			return nil, err
+		}
+	}
+
+	buf := make([]byte, HeaderSize)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, ErrMagicNumber
+		}
+
+		return nil, err
+	}
+
+	header, err := unmarshalHeader(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	if !header.RequiredFeatures.Has(FeatureStreaming) {
+		return nil, ErrNotStreamable
+	}
+
+	slots, _, err := readKeySlots(r)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, nonceSize)
+	if _, err := io.ReadFull(r, nonce); err != nil {
+		return nil, err
+	}
+
+	dataKey, _, err := unlockKeySlots(slots, key, s.keyfiles, header)
+	if err != nil {
+		return nil, err
+	}
+
+	keys, err := deriveKeys(dataKey, nonce, header)
+	if err != nil {
+		return nil, err
+	}
+
+	// withhold the master MAC and any signature
+	trailerSize := sha512.Size
+	if header.RequiredFeatures.Has(FeatureSignature) {
+		trailerSize += ed25519.SignatureSize
+	}
+
+	trailer, err := newTrailerReader(r, trailerSize)
+	if err != nil {
+		return nil, err
+	}
+
+	masterMac := hmac.New(sha512.New, keys.mac)
+	tag := &tagWriter{}
+
+	var plain io.Reader
+	if chunkedSuite(header.CipherSuite) {
+		aead, err := newAEAD(header.CipherSuite, keys.cipher)
+		if err != nil {
+			return nil, err
+		}
+
+		// ciphertext -> master MAC
+		//            -> AEAD chunks -> plaintext -> block tag
+		chunks := newChunkReader(io.TeeReader(trailer, masterMac), aead, nonce)
+		plain = io.TeeReader(chunks, tag)
+	} else {
+		block, err := aes.NewCipher(keys.cipher)
+		if err != nil {
+			return nil, err
+		}
+
+		// ciphertext -> master MAC
+		//            -> AES_256_CTR -> plaintext
+		plain = cipher.StreamReader{
+			S: cipher.NewCTR(block, nonce[:block.BlockSize()]),
+			R: io.TeeReader(trailer, io.MultiWriter(masterMac, tag)),
+		}
+	}
+
+	s.plain = &countReader{r: plain}
+	s.trailer = trailer
+	s.masterMac = masterMac
+	s.tag = tag
+	s.keys = keys
+
+	return s, nil
+}
+
+// Next advances to the next file in the archive. Once every file has been
+// read the master MAC is verified and io.EOF is returned.
+func (s *StreamReader) Next() (*File, error) {
+	s.current = nil
+
+	if s.done {
+		return nil, io.EOF
+	}
+
+	if s.next >= len(s.files) {
+		if err := s.readBlock(); err != nil {
+			// only verify reports the end of the archive, a stream which
+			// ends before the almanac frame has been truncated
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+
+			return nil, err
+		}
+
+		if s.done {
+			return nil, s.verify()
+		}
+	}
+
+	f := &s.files[s.next]
+	s.current = bytes.NewReader(s.block[s.offset:f.End(s.offset)])
+	s.offset = f.End(s.offset)
+	s.next++
+
+	return f, nil
+}
+
+// Read reads from the current file
+func (s *StreamReader) Read(p []byte) (int, error) {
+	if s.current == nil {
+		return 0, io.EOF
+	}
+
+	return s.current.Read(p)
+}
+
+// readBlock reads the next compression block and its end frame, done is set
+// if the almanac was reached instead.
+//
+// The compressed block is held in memory until the block's tag has been
+// checked, as the tag follows the block's ciphertext.
+func (s *StreamReader) readBlock() error {
+	offset := s.plain.n
+	s.tag.h = newTag(s.keys.block, offset)
+
+	frames := &frameReader{r: s.plain}
+	if err := frames.readHeader(); err != nil {
+		return err
+	}
+
+	if frames.kind == frameAlmanac {
+		s.tag.h = nil
+		s.done = true
+		return nil
+	}
+
+	// the block is unauthenticated until its tag is read, so its length is
+	// limited before it is held in memory
+	compressed, err := io.ReadAll(io.LimitReader(frames, s.maxBlock+1))
+	if err != nil {
+		return err
+	}
+
+	if int64(len(compressed)) > s.maxBlock {
+		return ErrStreamBlockSize
+	}
+
+	if frames.kind != frameEnd {
+		return ErrFrame
+	}
+
+	if int64(frames.length) > s.maxBlock {
+		return ErrStreamBlockSize
+	}
+
+	buf := make([]byte, frames.length)
+	if _, err := io.ReadFull(s.plain, buf); err != nil {
+		return err
+	}
+
+	expected := s.tag.h.Sum(nil)
+	s.tag.h = nil
+
+	tag := make([]byte, BlockTagSize)
+	if _, err := io.ReadFull(s.plain, tag); err != nil {
+		return err
+	}
+
+	if !hmac.Equal(expected, tag) {
+		return ErrIntegrityFailed
+	}
+
+	files, err := unmarshalEntries(buf, offset)
+	if err != nil {
+		return err
+	}
+
+	_, size := blockEnd(files, 0)
+
+	block := make(Block, size)
+	if _, err := io.ReadFull(brotli.NewReader(bytes.NewReader(compressed)), block); err != nil {
+		return err
+	}
+
+	s.block = block
+	s.files = files
+	s.next = 0
+	s.offset = 0
+
+	return nil
+}
+
+// verify reads the remainder of the archive and checks the master MAC
+func (s *StreamReader) verify() error {
+	if _, err := io.Copy(io.Discard, s.plain); err != nil {
+		return err
+	}
+
+	if !hmac.Equal(s.masterMac.Sum(nil), s.trailer.Trailer()[:sha512.Size]) {
+		return ErrIntegrityFailed
+	}
+
+	return io.EOF
+}
+
+// trailerReader reads from r but withholds the final n bytes, which are
+// returned by Trailer once r has been exhausted
+type trailerReader struct {
+	r    io.Reader
+	held []byte
+}
+
+func newTrailerReader(r io.Reader, n int) (*trailerReader, error) {
+	held := make([]byte, n)
+	if _, err := io.ReadFull(r, held); err != nil {
+		return nil, err
+	}
+
+	return &trailerReader{r: r, held: held}, nil
+}
+
+func (t *trailerReader) Read(p []byte) (int, error) {
+	n, err := t.r.Read(p)
+	if n == 0 {
+		return 0, err
+	}
+
+	// return the oldest held bytes and hold the newest
+	buf := append(t.held, p[:n]...)
+	copy(p, buf[:n])
+	t.held = append(t.held[:0], buf[n:]...)
+
+	return n, err
+}
+
+// Trailer returns the withheld bytes
+func (t *trailerReader) Trailer() []byte {
+	return t.held
+}
+
+// tagWriter writes to h when it is set
+type tagWriter struct {
+	h hash.Hash
+}
+
+func (t *tagWriter) Write(p []byte) (int, error) {
+	if t.h != nil {
+		t.h.Write(p)
+	}
+
+	return len(p), nil
+}
+
+// countReader counts the bytes read from r
+type countReader struct {
+	r io.Reader
+	n uint64
+}
+
+func (c *countReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += uint64(n)
+	return n, err
+}
@@ -0,0 +1,206 @@
+package zar
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+	"testing/iotest"
+)
+
+func encodeStreamArchive(t *testing.T, contents [][]byte, opts ...Option) []byte {
+	t.Helper()
+
+	output := bytes.NewBuffer(nil)
+	archive, err := New(output, testArchiveKey, append([]Option{WithStreaming(), WithBlockSize(1024)}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, c := range contents {
+		if _, err := archive.Add(string(rune('a'+i))+".bin", uint64(i), bytes.NewReader(c)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return output.Bytes()
+}
+
+func streamContents(t *testing.T) [][]byte {
+	// larger than a frame and incompressible
+	large := make([]byte, maxFrameSize*2+100)
+	if _, err := rand.Read(large); err != nil {
+		t.Fatal(err)
+	}
+
+	return [][]byte{[]byte("first"), []byte("second"), large, []byte("last")}
+}
+
+func TestStreamReader(t *testing.T) {
+	contents := streamContents(t)
+	archive := encodeStreamArchive(t, contents)
+
+	// hide the ReaderAt and Seeker implementations
+	s, err := NewStreamReader(iotest.HalfReader(bytes.NewReader(archive)), testArchiveKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, c := range contents {
+		f, err := s.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if f.Modified != uint64(i) {
+			t.Fatalf("expected modified %d got %d", i, f.Modified)
+		}
+
+		buf, err := io.ReadAll(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(buf, c) {
+			t.Fatalf("%s: contents did not match", f.Name)
+		}
+	}
+
+	if _, err := s.Next(); err != io.EOF {
+		t.Fatalf("expected EOF got %v", err)
+	}
+}
+
+func TestStreamArchiveRandomAccess(t *testing.T) {
+	contents := streamContents(t)
+	archive := encodeStreamArchive(t, contents)
+
+	d, err := NewDecoder(bytes.NewReader(archive), testArchiveKey, int64(len(archive)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := d.Open("c.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(buf, contents[2]) {
+		t.Fatal("contents did not match")
+	}
+
+	reader, err := d.Reader()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range contents {
+		if _, err := reader.Next(); err != nil {
+			t.Fatal(err)
+		}
+
+		if buf, _ := io.ReadAll(reader); !bytes.Equal(buf, c) {
+			t.Fatal("contents did not match")
+		}
+	}
+}
+
+func TestStreamReaderTampered(t *testing.T) {
+	archive := encodeStreamArchive(t, [][]byte{[]byte("contents")})
+	archive[len(archive)-1] ^= 1
+
+	s, err := NewStreamReader(bytes.NewReader(archive), testArchiveKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Next(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Next(); err != ErrIntegrityFailed {
+		t.Fatalf("expected %q got %v", ErrIntegrityFailed, err)
+	}
+}
+
+func TestStreamReaderNotStreamable(t *testing.T) {
+	archive, err := encodeArchive()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewStreamReader(bytes.NewReader(archive), testArchiveKey); err != ErrNotStreamable {
+		t.Fatalf("expected %q got %v", ErrNotStreamable, err)
+	}
+}
+
+func TestStreamReaderMaxBlock(t *testing.T) {
+	contents := streamContents(t)
+	archive := encodeStreamArchive(t, contents)
+
+	s, err := NewStreamReader(bytes.NewReader(archive), testArchiveKey, WithMaxStreamBlock(maxFrameSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the first block holds the small files, the large file is too big
+	for range contents[:2] {
+		if _, err := s.Next(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := s.Next(); err != ErrStreamBlockSize {
+		t.Fatalf("expected %q got %v", ErrStreamBlockSize, err)
+	}
+
+	if _, err := NewStreamReader(bytes.NewReader(archive), testArchiveKey, WithMaxStreamBlock(0)); err != ErrBlockSize {
+		t.Fatalf("expected %q got %v", ErrBlockSize, err)
+	}
+}
+
+func TestStreamReaderTruncated(t *testing.T) {
+	output := bytes.NewBuffer(nil)
+	archive, err := New(output, testArchiveKey, WithStreaming(), WithBlockSize(1), WithKDFParams(testKDFParams))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"a", "b", "c"} {
+		if _, err := archive.Add(name, 0, bytes.NewBufferString(name+" contents")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := output.Bytes()
+
+	// every truncation must fail rather than end the stream cleanly
+	for n := 0; n < len(buf); n++ {
+		s, err := NewStreamReader(bytes.NewReader(buf[:n]), testArchiveKey)
+		if err != nil {
+			continue
+		}
+
+		for {
+			_, err := s.Next()
+			if err == io.EOF {
+				t.Fatalf("archive truncated to %d bytes ended without error", n)
+			} else if err != nil {
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package zar
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+)
+
+// AddFS walks the file tree rooted at root and adds every regular file to the
+// archive.
+//
+// Files are named by their forward slash path relative to root and take
+// their modified date from fs.FileInfo. Directories are not stored, they are
+// implied by the names of the files within them. Special files such as
+// symlinks, devices and pipes are skipped and their paths are returned so the
+// caller can report them.
+func (e *Encoder) AddFS(fsys fs.FS, root string) (skipped []string, err error) {
+	err = fs.WalkDir(fsys, root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			return nil
+		}
+
+		if !d.Type().IsRegular() {
+			skipped = append(skipped, p)
+			return nil
+		}
+
+		name := relativeName(root, p)
+		if !validateName(name) {
+			return &fs.PathError{Op: "add", Path: p, Err: ErrFileName}
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		return e.addFile(fsys, p, name, info)
+	})
+
+	return skipped, err
+}
+
+// AddDir adds every regular file within the directory on the OS file system
+// to the archive. See AddFS.
+func (e *Encoder) AddDir(dir string) ([]string, error) {
+	return e.AddFS(os.DirFS(dir), ".")
+}
+
+func (e *Encoder) addFile(fsys fs.FS, p, name string, info fs.FileInfo) error {
+	f, err := fsys.Open(p)
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	modified := info.ModTime().Unix()
+	if modified < 0 {
+		modified = 0
+	}
+
+	_, err = e.Add(name, uint64(modified), f)
+	return err
+}
+
+// relativeName returns the path of p relative to root. If root is itself a
+// file its base name is used.
+func relativeName(root, p string) string {
+	if p == root {
+		return path.Base(p)
+	}
+
+	if root == "." {
+		return p
+	}
+
+	return strings.TrimPrefix(p, root+"/")
+}
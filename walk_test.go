@@ -0,0 +1,103 @@
+package zar
+
+import (
+	"bytes"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestAddFS(t *testing.T) {
+	modified := time.Unix(1656000000, 0)
+	src := fstest.MapFS{
+		"root/a.txt":       {Data: []byte("file a"), ModTime: modified},
+		"root/dir/b.txt":   {Data: []byte("file b"), ModTime: modified},
+		"root/empty":       {Mode: fs.ModeDir},
+		"outside/c.txt":    {Data: []byte("not added")},
+		"root/dir/pipe":    {Mode: fs.ModeNamedPipe},
+		"root/dir/z/c.txt": {Data: []byte("file c")},
+	}
+
+	output := bytes.NewBuffer(nil)
+	archive, err := New(output, testArchiveKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	skipped, err := archive.AddFS(src, "root")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(skipped) != 1 || skipped[0] != "root/dir/pipe" {
+		t.Fatalf("unexpected skipped files %q", skipped)
+	}
+
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := NewDecoder(bytes.NewReader(output.Bytes()), testArchiveKey, int64(output.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fsys, err := d.FS()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := fstest.TestFS(fsys, "a.txt", "dir/b.txt", "dir/z/c.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := fs.Stat(fsys, "dir/b.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !info.ModTime().Equal(modified) {
+		t.Fatalf("expected modified %s got %s", modified, info.ModTime())
+	}
+}
+
+func TestAddDir(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "sub"), 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "sub", "file.txt"), []byte("contents"), 0666); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Symlink("sub/file.txt", filepath.Join(dir, "link")); err != nil {
+		t.Skip("symlinks unsupported:", err)
+	}
+
+	output := bytes.NewBuffer(nil)
+	archive, err := New(output, testArchiveKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	skipped, err := archive.AddDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(skipped) != 1 || skipped[0] != "link" {
+		t.Fatalf("unexpected skipped files %q", skipped)
+	}
+
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(archive.almanac) != 1 || archive.almanac[0].Name != "sub/file.txt" {
+		t.Fatalf("unexpected almanac %+v", archive.almanac)
+	}
+}